@@ -1,15 +1,199 @@
-use dagger_sdk::{Container, Directory, Query, Service};
+use std::time::{Duration, SystemTime, UNIX_EPOCH};
 
-/// Rust build container with Diesel/PG deps and cargo caches.
-pub fn rust_base(client: &Query, source: Directory) -> Container {
-    client
+use clap::{Args, ValueEnum};
+use dagger_sdk::{Container, Directory, Query, Secret, Service};
+
+/// Options shared by every container the pipeline builds.
+#[derive(Args, Clone, Debug)]
+pub struct BaseOpts {
+    /// Fail heavy stages up front when the engine has less free disk than this (0 disables)
+    #[arg(long, global = true, default_value_t = 5)]
+    pub min_free_gb: u64,
+    /// Pin the Rust base image to a digest (sha256:...); see `resolve-digests`
+    #[arg(long, global = true)]
+    pub rust_base_digest: Option<String>,
+    /// Pin the PostgreSQL image to a digest (sha256:...); see `resolve-digests`
+    #[arg(long, global = true)]
+    pub pg_digest: Option<String>,
+    /// Rust toolchain version of the `rust:<version>-bookworm` base image
+    #[arg(long, global = true, default_value = "1.85")]
+    pub rust_version: String,
+    /// PostgreSQL version of the `postgres:<version>-alpine` service image
+    #[arg(long, global = true, default_value = "18")]
+    pub postgres_version: String,
+    /// Release channel; beta and nightly are installed with rustup on top of the base image
+    #[arg(long, global = true, value_enum, default_value_t = RustChannel::Stable)]
+    pub rust_channel: RustChannel,
+    /// Compile through sccache; a local cache volume unless --sccache-bucket is set
+    #[arg(long, global = true)]
+    pub sccache: bool,
+    /// Shared remote sccache bucket (implies --sccache). S3 credentials come from the host's
+    /// AWS_ACCESS_KEY_ID/AWS_SECRET_ACCESS_KEY, a GCS key JSON from SCCACHE_GCS_KEY
+    #[arg(long, global = true)]
+    pub sccache_bucket: Option<String>,
+    /// Object store of --sccache-bucket
+    #[arg(long, global = true, value_enum, default_value_t = SccacheBackend::S3)]
+    pub sccache_backend: SccacheBackend,
+    /// Region of an S3 --sccache-bucket
+    #[arg(long, global = true)]
+    pub sccache_region: Option<String>,
+    /// Prebuilt toolchain image from `publish-base-image` to start from instead of the Rust
+    /// base image plus apt-get; must match --rust-version and --rust-channel
+    #[arg(long, global = true)]
+    pub base_image: Option<String>,
+    /// Suffix for the `cargo-target` cache volume (e.g. a branch name or toolchain), so
+    /// branches keep separate incremental artifacts instead of evicting each other's
+    #[arg(long, global = true)]
+    pub cache_key: Option<String>,
+    /// Attempts for network-bound steps (apt, rustup, tool downloads and installs) before
+    /// the step fails; 1 disables retrying
+    #[arg(long, global = true, default_value_t = 3)]
+    pub retries: u32,
+    /// Seconds before the first retry, doubling after each further failure
+    #[arg(long, global = true, default_value_t = 5)]
+    pub retry_backoff_secs: u64,
+    /// Build into `/app/target/<subdir>` so concurrent runs on different toolchains
+    /// don't queue on one cargo build lock; set programmatically, not a flag.
+    #[arg(skip)]
+    pub target_subdir: Option<String>,
+}
+
+/// Object store backing a remote sccache.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, ValueEnum)]
+pub enum SccacheBackend {
+    S3,
+    Gcs,
+}
+
+/// Rust release channel the pipeline builds with.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, ValueEnum)]
+pub enum RustChannel {
+    /// The `--rust-version` toolchain shipped in the base image
+    Stable,
+    Beta,
+    Nightly,
+}
+
+impl RustChannel {
+    pub fn name(self) -> &'static str {
+        match self {
+            RustChannel::Stable => "stable",
+            RustChannel::Beta => "beta",
+            RustChannel::Nightly => "nightly",
+        }
+    }
+}
+
+/// `tag@digest` when a digest is given, otherwise the tag unchanged.
+fn pinned(tag: &str, digest: Option<&str>) -> String {
+    match digest {
+        Some(digest) => format!("{tag}@{digest}"),
+        None => tag.to_string(),
+    }
+}
+
+/// Floating Rust base image tag for `--rust-version`.
+fn rust_tag(opts: &BaseOpts) -> String {
+    format!("rust:{}-bookworm", opts.rust_version)
+}
+
+/// Floating PostgreSQL image tag for `--postgres-version`.
+fn pg_tag(opts: &BaseOpts) -> String {
+    format!("postgres:{}-alpine", opts.postgres_version)
+}
+
+/// Rust base image reference, pinned when `--rust-base-digest` is set.
+/// The cargo caches are shared across versions and channels: cargo keys build
+/// artifacts by rustc version, so switching toolchains never reuses a stale artifact.
+pub fn rust_image(opts: &BaseOpts) -> String {
+    pinned(&rust_tag(opts), opts.rust_base_digest.as_deref())
+}
+
+/// PostgreSQL image reference, pinned when `--pg-digest` is set.
+pub fn pg_image(opts: &BaseOpts) -> String {
+    pinned(&pg_tag(opts), opts.pg_digest.as_deref())
+}
+
+impl BaseOpts {
+    /// Reject malformed versions and digests before they turn into a confusing pull failure.
+    pub fn validate(&self) -> eyre::Result<()> {
+        for (flag, version) in [
+            ("--rust-version", &self.rust_version),
+            ("--postgres-version", &self.postgres_version),
+        ] {
+            let valid_char = |c: char| c.is_ascii_alphanumeric() || matches!(c, '.' | '-' | '_');
+            if version.is_empty() || !version.chars().all(valid_char) {
+                return Err(eyre::eyre!(
+                    "invalid {flag} '{version}', expected a version like 1.85 or 18"
+                ));
+            }
+        }
+        if let Some(key) = &self.cache_key {
+            let valid_char = |c: char| c.is_ascii_alphanumeric() || matches!(c, '.' | '-' | '_');
+            if key.is_empty() || !key.chars().all(valid_char) {
+                return Err(eyre::eyre!(
+                    "invalid --cache-key '{key}', expected letters, digits, '.', '-' or '_'"
+                ));
+            }
+        }
+        if self.retries == 0 {
+            return Err(eyre::eyre!("--retries must be at least 1"));
+        }
+        for digest in [&self.rust_base_digest, &self.pg_digest].into_iter().flatten() {
+            let hex = digest.strip_prefix("sha256:").unwrap_or_default();
+            if hex.len() != 64 || !hex.chars().all(|c| c.is_ascii_hexdigit()) {
+                return Err(eyre::eyre!(
+                    "invalid image digest '{digest}', expected sha256:<64 hex chars>"
+                ));
+            }
+        }
+        Ok(())
+    }
+}
+
+/// System packages the Rust build needs for Diesel/PG.
+pub const BUILD_PACKAGES: [&str; 4] =
+    ["libpq-dev", "pkg-config", "build-essential", "postgresql-client"];
+
+/// The Rust base image with `BUILD_PACKAGES` and, off stable, the `--rust-channel`
+/// toolchain installed: everything `rust_toolchain` adds before the caches, and what
+/// `publish-base-image` bakes into an image.
+pub fn toolchain_image(client: &Query, opts: &BaseOpts) -> Container {
+    let mut container = client
         .container()
-        .from("rust:1.85-bookworm")
-        .with_exec(vec!["apt-get", "update"])
-        .with_exec(vec![
-            "apt-get", "install", "-y",
-            "libpq-dev", "pkg-config", "build-essential", "postgresql-client",
-        ])
+        .from(rust_image(opts))
+        .with_exec(retried(&["apt-get", "update"], opts))
+        .with_exec(retried(&[&["apt-get", "install", "-y"][..], &BUILD_PACKAGES].concat(), opts));
+    if opts.rust_channel != RustChannel::Stable {
+        let channel = opts.rust_channel.name();
+        container = container
+            .with_exec(retried(
+                &[
+                    "rustup", "toolchain", "install", channel, "--profile", "minimal",
+                    "--component", "rustfmt", "--component", "clippy", "--allow-downgrade",
+                ],
+                opts,
+            ))
+            .with_env_variable("RUSTUP_TOOLCHAIN", channel);
+    }
+    container
+}
+
+/// Rust toolchain container with Diesel/PG deps and cargo caches, but no source.
+/// Tools installed on top of this (`cargo install`, rustup components) stay cached
+/// across source changes; mount the source afterwards with `with_source`.
+/// With `--base-image` the prebuilt image replaces the `toolchain_image` steps.
+pub fn rust_toolchain(client: &Query, opts: &BaseOpts) -> Container {
+    let mut container = match &opts.base_image {
+        Some(image) => client.container().from(image.as_str()),
+        None => toolchain_image(client, opts),
+    };
+
+    if opts.sccache || opts.sccache_bucket.is_some() {
+        container = with_sccache(client, container, opts);
+    }
+
+    container
         .with_mounted_cache(
             "/usr/local/cargo/registry",
             client.cache_volume("cargo-registry"),
@@ -20,23 +204,302 @@ pub fn rust_base(client: &Query, source: Directory) -> Container {
         )
         .with_mounted_cache(
             "/app/target",
-            client.cache_volume("cargo-target"),
+            client.cache_volume(target_volume(opts)),
         )
         .with_workdir("/app")
-        .with_directory("/app", source)
-        .with_env_variable("CARGO_TARGET_DIR", "/app/target")
+        .with_env_variable("CARGO_TARGET_DIR", target_dir(opts))
         .with_env_variable("RUST_BACKTRACE", "1")
 }
 
-/// PostgreSQL 18 service for integration tests.
-pub fn postgres(client: &Query) -> Service {
+/// sccache release installed by `--sccache`.
+const SCCACHE_VERSION: &str = "0.8.2";
+
+/// `container` with sccache installed as `RUSTC_WRAPPER`, caching to `--sccache-bucket`
+/// when set and to the `sccache` cache volume otherwise. Bucket credentials are read
+/// from the host environment and mounted as secrets. Incremental compilation is turned
+/// off, since sccache cannot cache incremental builds.
+fn with_sccache(client: &Query, container: Container, opts: &BaseOpts) -> Container {
+    let release = format!("sccache-v{SCCACHE_VERSION}-x86_64-unknown-linux-musl");
+    let install = format!(
+        "curl -fsSL https://github.com/mozilla/sccache/releases/download/v{SCCACHE_VERSION}/\
+         {release}.tar.gz | tar -xz -C /tmp && mv /tmp/{release}/sccache /usr/local/bin/"
+    );
+    let mut container = container
+        .with_exec(retried(&["sh", "-c", &install], opts))
+        .with_env_variable("RUSTC_WRAPPER", "sccache")
+        .with_env_variable("CARGO_INCREMENTAL", "0");
+
+    let secret_env = |name: &str| {
+        let value = std::env::var(name).unwrap_or_default();
+        (!value.is_empty()).then(|| client.set_secret(name.to_lowercase(), value))
+    };
+    let Some(bucket) = &opts.sccache_bucket else {
+        return container
+            .with_mounted_cache("/root/.cache/sccache", client.cache_volume("sccache"))
+            .with_env_variable("SCCACHE_DIR", "/root/.cache/sccache");
+    };
+    match opts.sccache_backend {
+        SccacheBackend::S3 => {
+            container = container.with_env_variable("SCCACHE_BUCKET", bucket.as_str());
+            if let Some(region) = &opts.sccache_region {
+                container = container.with_env_variable("SCCACHE_REGION", region.as_str());
+            }
+            for name in ["AWS_ACCESS_KEY_ID", "AWS_SECRET_ACCESS_KEY"] {
+                if let Some(secret) = secret_env(name) {
+                    container = container.with_secret_variable(name, secret);
+                }
+            }
+        }
+        SccacheBackend::Gcs => {
+            container = container
+                .with_env_variable("SCCACHE_GCS_BUCKET", bucket.as_str())
+                .with_env_variable("SCCACHE_GCS_RW_MODE", "READ_WRITE");
+            if let Some(key) = secret_env("SCCACHE_GCS_KEY") {
+                container = container
+                    .with_mounted_secret("/run/secrets/sccache-gcs.json", key)
+                    .with_env_variable("SCCACHE_GCS_KEY_PATH", "/run/secrets/sccache-gcs.json");
+            }
+        }
+    }
+    container
+}
+
+/// Name of the cache volume mounted at `/app/target`: `cargo-target`, suffixed with
+/// `--cache-key` when set. The registry and git caches only hold immutable downloads,
+/// so they stay shared.
+fn target_volume(opts: &BaseOpts) -> String {
+    match &opts.cache_key {
+        Some(key) => format!("cargo-target-{key}"),
+        None => "cargo-target".to_string(),
+    }
+}
+
+/// Cargo target dir inside the `cargo-target` cache mount.
+pub fn target_dir(opts: &BaseOpts) -> String {
+    match &opts.target_subdir {
+        Some(subdir) => format!("/app/target/{subdir}"),
+        None => "/app/target".to_string(),
+    }
+}
+
+/// Mount the workspace source at `/app`.
+pub fn with_source(container: Container, source: Directory) -> Container {
+    container.with_directory("/app", source)
+}
+
+/// Rust build container with Diesel/PG deps, cargo caches and the source at `/app`.
+pub fn rust_base(client: &Query, source: Directory, opts: &BaseOpts) -> Container {
+    with_source(rust_toolchain(client, opts), source)
+}
+
+/// Database and role the `postgres` service is initialised with.
+pub const PG_ENV: [(&str, &str); 2] = [("POSTGRES_DB", "erp_test"), ("POSTGRES_USER", "erp")];
+
+/// Host variable holding the `postgres` service password.
+pub const PG_PASSWORD_ENV: &str = "CI_DB_PASSWORD";
+
+/// Password used when `PG_PASSWORD_ENV` is unset; fine for the throwaway CI database.
+pub const DEFAULT_PG_PASSWORD: &str = "erp_password";
+
+/// The `postgres` service password as a Dagger secret, so it stays out of logs and cache keys.
+pub fn pg_password(client: &Query) -> Secret {
+    client.set_secret("pg-password", pg_password_value())
+}
+
+fn pg_password_value() -> String {
+    let password = std::env::var(PG_PASSWORD_ENV).unwrap_or_default();
+    if password.is_empty() {
+        DEFAULT_PG_PASSWORD.to_string()
+    } else {
+        password
+    }
+}
+
+/// Connection URL for the `postgres` service when bound as host `db`, as a secret since
+/// it embeds the password. Mount it with `with_secret_variable("DATABASE_URL", ..)`.
+pub fn pg_url(client: &Query) -> Secret {
+    // Percent-encode everything outside the URL unreserved set.
+    let password: String = pg_password_value()
+        .bytes()
+        .map(|b| match b {
+            b'A'..=b'Z' | b'a'..=b'z' | b'0'..=b'9' | b'-' | b'.' | b'_' | b'~' => {
+                (b as char).to_string()
+            }
+            _ => format!("%{b:02X}"),
+        })
+        .collect();
+    let url = format!("postgres://{}:{password}@db:{PG_PORT}/{}", PG_ENV[1].1, PG_ENV[0].1);
+    client.set_secret("pg-url", url)
+}
+
+/// Port the `postgres` service listens on.
+pub const PG_PORT: isize = 5432;
+
+/// How long a service may take to accept connections.
+pub const SERVICE_READY_TIMEOUT: Duration = Duration::from_secs(60);
+
+/// Start `service` and wait for Dagger's health check, which passes once every exposed
+/// port accepts TCP connections, failing with an error naming `name` after `timeout`.
+/// A started service keeps running for the session, so containers binding it afterwards
+/// connect straight away.
+pub async fn wait_for_service(
+    service: &Service,
+    name: &str,
+    timeout: Duration,
+) -> eyre::Result<()> {
+    match tokio::time::timeout(timeout, service.start()).await {
+        Ok(Ok(_)) => Ok(()),
+        Ok(Err(e)) => Err(eyre::eyre!("{name} failed to start: {e}")),
+        Err(_) => Err(eyre::eyre!(
+            "{name} not accepting connections after {}s",
+            timeout.as_secs()
+        )),
+    }
+}
+
+/// Runs `"$@"` up to `$1` times, sleeping `$2` seconds after the first failure and twice
+/// as long after each further one. Invoked as `sh -c RETRY_SH retry <attempts> <secs> cmd..`.
+const RETRY_SH: &str = r#"
+attempts=$1 delay=$2
+shift 2
+n=1
+until "$@"; do
+    if [ "$n" -ge "$attempts" ]; then
+        echo "retry: '$*' failed $n time(s), giving up" >&2
+        exit 1
+    fi
+    echo "retry: '$*' failed (attempt $n/$attempts), retrying in ${delay}s" >&2
+    sleep "$delay"
+    n=$((n + 1))
+    delay=$((delay * 2))
+done
+"#;
+
+/// `cmd` as a `with_exec` command retried per `--retries`/`--retry-backoff-secs`. Only
+/// for steps that fail on flaky networks (package mirrors, crates.io, downloads) and
+/// are safe to repeat; a real build or test failure would just be retried into the
+/// same result.
+pub fn retried(cmd: &[&str], opts: &BaseOpts) -> Vec<String> {
+    let mut args = vec![
+        "sh".to_string(),
+        "-c".to_string(),
+        RETRY_SH.to_string(),
+        "retry".to_string(),
+        opts.retries.to_string(),
+        opts.retry_backoff_secs.to_string(),
+    ];
+    args.extend(cmd.iter().map(|arg| arg.to_string()));
+    args
+}
+
+/// PostgreSQL service for integration tests (`--postgres-version`, default 18). The image
+/// only listens on TCP once initialisation is done, so the port health check doubles as
+/// readiness; see `ready_postgres`.
+pub fn postgres(client: &Query, opts: &BaseOpts) -> Service {
+    postgres_container(client, opts).as_service()
+}
+
+/// The `postgres` container before it becomes a service, for stages that add to the
+/// server, such as extensions.
+pub fn postgres_container(client: &Query, opts: &BaseOpts) -> Container {
+    PG_ENV
+        .iter()
+        .fold(
+            client.container().from(pg_image(opts)),
+            |container, (name, value)| container.with_env_variable(*name, *value),
+        )
+        .with_secret_variable("POSTGRES_PASSWORD", pg_password(client))
+        .with_exposed_port(PG_PORT)
+}
+
+/// `postgres`, started and accepting connections; see `wait_for_service`.
+pub async fn ready_postgres(client: &Query, opts: &BaseOpts) -> eyre::Result<Service> {
+    let pg = postgres(client, opts);
+    wait_for_service(&pg, "postgres", SERVICE_READY_TIMEOUT).await?;
+    Ok(pg)
+}
+
+/// `ready_postgres` as a service of its own for `instance`. Dagger shares identical
+/// services within a session, so concurrent tests would otherwise meet in one database.
+pub async fn ready_isolated_postgres(
+    client: &Query,
+    opts: &BaseOpts,
+    instance: &str,
+) -> eyre::Result<Service> {
+    let pg = postgres_container(client, opts)
+        .with_env_variable("CI_PG_INSTANCE", instance)
+        .as_service();
+    wait_for_service(&pg, &format!("postgres ({instance})"), SERVICE_READY_TIMEOUT).await?;
+    Ok(pg)
+}
+
+/// Port the `redis` service listens on.
+pub const REDIS_PORT: isize = 6379;
+
+/// Redis service for the job queue and cache.
+pub fn redis(client: &Query) -> Service {
+    client
+        .container()
+        .from("redis:7.4-alpine")
+        .with_exposed_port(REDIS_PORT)
+        .as_service()
+}
+
+/// Ports the `mailpit` SMTP server and its HTTP API listen on.
+pub const MAILPIT_SMTP_PORT: isize = 1025;
+pub const MAILPIT_API_PORT: isize = 8025;
+
+/// Mailpit SMTP capture service: accepts any mail without authentication and exposes
+/// the captured messages through its HTTP API.
+pub fn mailpit(client: &Query) -> Service {
+    client
+        .container()
+        .from("axllent/mailpit:v1.21.8")
+        .with_exposed_port(MAILPIT_SMTP_PORT)
+        .with_exposed_port(MAILPIT_API_PORT)
+        .as_service()
+}
+
+/// Port the `minio` S3 API listens on.
+pub const MINIO_PORT: isize = 9000;
+
+/// Bucket created in `minio` for attachments.
+pub const MINIO_BUCKET: &str = "erp-attachments";
+
+/// Host variables overriding the `minio` access and secret key; both default to
+/// `minioadmin`, fine for the throwaway CI store.
+pub const MINIO_KEY_ENV: [&str; 2] = ["CI_S3_ACCESS_KEY", "CI_S3_SECRET_KEY"];
+
+/// The `minio` access and secret key as Dagger secrets.
+pub fn minio_keys(client: &Query) -> [Secret; 2] {
+    MINIO_KEY_ENV.map(|name| {
+        let value = std::env::var(name).unwrap_or_default();
+        let value = if value.is_empty() { "minioadmin".to_string() } else { value };
+        client.set_secret(name.to_lowercase(), value)
+    })
+}
+
+/// MinIO single-node S3-compatible object store for attachment storage.
+pub fn minio(client: &Query) -> Service {
+    let [access_key, secret_key] = minio_keys(client);
     client
         .container()
-        .from("postgres:18-alpine")
-        .with_env_variable("POSTGRES_DB", "erp_test")
-        .with_env_variable("POSTGRES_USER", "erp")
-        .with_env_variable("POSTGRES_PASSWORD", "erp_password")
-        .with_exposed_port(5432)
+        .from("minio/minio:RELEASE.2024-11-07T00-52-20Z")
+        .with_secret_variable("MINIO_ROOT_USER", access_key)
+        .with_secret_variable("MINIO_ROOT_PASSWORD", secret_key)
+        .with_exposed_port(MINIO_PORT)
+        .with_default_args(vec!["minio", "server", "/data"])
+        .as_service()
+}
+
+/// CockroachDB single-node service (insecure mode, Postgres wire protocol on 26257).
+pub fn cockroach(client: &Query) -> Service {
+    client
+        .container()
+        .from("cockroachdb/cockroach:latest-v24.3")
+        .with_default_args(vec!["start-single-node", "--insecure"])
+        .with_exposed_port(26257)
+        .with_exposed_port(8080)
         .as_service()
 }
 
@@ -46,6 +509,52 @@ pub fn node_base(client: &Query, static_dir: Directory) -> Container {
         .container()
         .from("node:22-slim")
         .with_mounted_cache("/app/node_modules", client.cache_volume("npm-cache"))
+        .with_mounted_cache("/root/.npm", client.cache_volume("npm-download-cache"))
         .with_workdir("/app")
         .with_directory("/app", static_dir)
 }
+
+/// Fail fast with an actionable error when the disk backing the cargo target cache
+/// has less than `min_free_gb` free. Runs `df` in a cache-busted branch of `container`
+/// so the probe is fresh every run without invalidating the build layers.
+pub async fn ensure_free_disk(container: &Container, opts: &BaseOpts) -> eyre::Result<()> {
+    if opts.min_free_gb == 0 {
+        return Ok(());
+    }
+
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let df = container
+        .with_env_variable("CI_DISK_PROBE", nonce.to_string())
+        .with_exec(vec!["df", "-Pk", "/app/target"])
+        .stdout()
+        .await?;
+
+    // POSIX df: Filesystem 1024-blocks Used Available Capacity Mounted-on
+    let available_kb: u64 = df
+        .lines()
+        .nth(1)
+        .and_then(|line| line.split_whitespace().nth(3))
+        .and_then(|avail| avail.parse().ok())
+        .ok_or_else(|| eyre::eyre!("could not parse df output:\n{df}"))?;
+    let free_gb = available_kb as f64 / (1024.0 * 1024.0);
+
+    if free_gb < opts.min_free_gb as f64 {
+        return Err(eyre::eyre!(
+            "insufficient disk: {free_gb:.1}gb free, need {}gb \
+             (prune the cargo-target cache or lower --min-free-gb)",
+            opts.min_free_gb
+        ));
+    }
+    Ok(())
+}
+
+/// Resolve the floating base image tags to `image@sha256:...` references,
+/// for capturing values to pass as `--rust-base-digest` / `--pg-digest`.
+pub async fn resolve_digests(client: &Query, opts: &BaseOpts) -> eyre::Result<String> {
+    let mut out = String::new();
+    for tag in [rust_tag(opts), pg_tag(opts)] {
+        let image_ref = client.container().from(tag.as_str()).image_ref().await?;
+        out.push_str(&format!("{tag} -> {image_ref}\n"));
+    }
+    Ok(out)
+}
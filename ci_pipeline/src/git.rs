@@ -0,0 +1,102 @@
+use dagger_sdk::{Container, Directory, Query};
+
+const GIT_IMAGE: &str = "alpine/git:2.47.1";
+
+/// Separates the fields and the records of `log`'s `--format`.
+const FIELD_SEP: char = '\u{1f}';
+const RECORD_SEP: char = '\u{1e}';
+
+/// `git` over `repo` (a host checkout including `.git`, which is all it needs) at `/repo`.
+pub fn container(client: &Query, repo: Directory) -> Container {
+    client
+        .container()
+        .from(GIT_IMAGE)
+        .with_directory("/repo", repo)
+        .with_workdir("/repo")
+        .with_exec(vec!["git", "config", "--global", "safe.directory", "*"])
+}
+
+/// The most recent tag reachable from `HEAD`, if any.
+pub async fn last_tag(client: &Query, repo: Directory) -> eyre::Result<Option<String>> {
+    let tag = container(client, repo)
+        .with_exec(vec!["sh", "-c", "git describe --tags --abbrev=0 2>/dev/null || true"])
+        .stdout()
+        .await?;
+    let tag = tag.trim();
+    Ok((!tag.is_empty()).then(|| tag.to_string()))
+}
+
+/// `HEAD`'s full commit hash.
+pub async fn head(client: &Query, repo: Directory) -> eyre::Result<String> {
+    let sha = container(client, repo).with_exec(vec!["git", "rev-parse", "HEAD"]).stdout().await?;
+    Ok(sha.trim().to_string())
+}
+
+#[derive(Debug)]
+pub struct Commit {
+    pub sha: String,
+    pub subject: String,
+    pub body: String,
+}
+
+/// A Conventional Commits subject, `type(scope)!: description`, with `breaking` also
+/// set by a `BREAKING CHANGE:` footer in the body.
+#[derive(Debug)]
+pub struct Conventional<'a> {
+    pub kind: &'a str,
+    pub scope: Option<&'a str>,
+    pub breaking: bool,
+    pub description: &'a str,
+}
+
+impl Commit {
+    pub fn short_sha(&self) -> &str {
+        &self.sha[..self.sha.len().min(7)]
+    }
+
+    /// The parsed subject, or `None` when it does not follow Conventional Commits.
+    pub fn conventional(&self) -> Option<Conventional<'_>> {
+        let (head, description) = self.subject.split_once(": ")?;
+        let (head, bang) = match head.strip_suffix('!') {
+            Some(head) => (head, true),
+            None => (head, false),
+        };
+        let (kind, scope) = match head.split_once('(') {
+            Some((kind, scope)) => (kind, Some(scope.strip_suffix(')')?)),
+            None => (head, None),
+        };
+        if kind.is_empty() || !kind.chars().all(|c| c.is_ascii_alphabetic()) {
+            return None;
+        }
+        let footer = |f: &str| self.body.lines().any(|l| l.starts_with(f));
+        Some(Conventional {
+            kind,
+            scope,
+            breaking: bang || footer("BREAKING CHANGE:") || footer("BREAKING-CHANGE:"),
+            description: description.trim(),
+        })
+    }
+}
+
+/// Commits in `range` (e.g. `v1.2.0..HEAD`, or `HEAD` for the whole history), newest
+/// first, merges excluded.
+pub async fn log(client: &Query, repo: Directory, range: &str) -> eyre::Result<Vec<Commit>> {
+    let format = format!("--format=%H{FIELD_SEP}%s{FIELD_SEP}%b{RECORD_SEP}");
+    let output = container(client, repo)
+        .with_exec(vec!["git", "log", "--no-merges", format.as_str(), range])
+        .stdout()
+        .await?;
+
+    Ok(output
+        .split(RECORD_SEP)
+        .filter_map(|record| {
+            let mut fields = record.trim_start_matches('\n').splitn(3, FIELD_SEP);
+            let sha = fields.next().filter(|s| !s.is_empty())?;
+            Some(Commit {
+                sha: sha.to_string(),
+                subject: fields.next()?.to_string(),
+                body: fields.next().unwrap_or_default().to_string(),
+            })
+        })
+        .collect())
+}
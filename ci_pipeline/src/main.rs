@@ -1,4 +1,9 @@
 mod containers;
+mod git;
+mod manifest;
+mod metadata;
+mod pipeline;
+mod severity;
 mod stages;
 
 use clap::{Parser, Subcommand};
@@ -7,6 +12,8 @@ use dagger_sdk::{Directory, HostDirectoryOpts, Query};
 #[derive(Parser)]
 #[command(name = "centrix-ci", about = "Centrix CI/CD Pipeline")]
 struct Cli {
+    #[command(flatten)]
+    base: containers::BaseOpts,
     #[command(subcommand)]
     command: Command,
 }
@@ -32,18 +39,44 @@ enum Command {
     Test {
         #[arg(long)]
         source: String,
+        #[command(flatten)]
+        proptest: stages::test::PropTestOpts,
+        /// Export the nextest JUnit XML report to this path
+        #[arg(long)]
+        junit_output: Option<String>,
     },
-    /// Module lifecycle integration test
+    /// Module lifecycle integration test (database password from CI_DB_PASSWORD env, if set)
     #[command(name = "integration-test")]
     IntegrationTest {
         #[arg(long)]
         source: String,
+        /// Output level: quiet, normal or debug
+        #[arg(long, value_enum, default_value_t)]
+        verbosity: stages::integration::Verbosity,
+        #[command(flatten)]
+        services: stages::integration::Services,
+        /// Directory of SQL and CSV fixtures loaded after the migrations
+        #[arg(long)]
+        fixtures: Option<String>,
+    },
+    /// Module lifecycle against CockroachDB, reporting incompatibilities
+    #[command(name = "cockroach-test")]
+    CockroachTest {
+        #[arg(long)]
+        source: String,
     },
-    /// Validate module manifests and XML
+    /// Validate module manifests, XML and code patterns
     #[command(name = "module-lint")]
     ModuleLint {
         #[arg(long)]
         source: String,
+        /// RelaxNG (.rng) or XSD (.xsd) schema for module XML (default: the workspace's
+        /// erp_core/schema/view.rng, if any)
+        #[arg(long)]
+        schema: Option<String>,
+        /// Print the findings (rule, severity, file, line) as JSON instead of text
+        #[arg(long)]
+        json: bool,
     },
     /// Build Tailwind CSS
     #[command(name = "tailwind-build")]
@@ -63,14 +96,768 @@ enum Command {
     SecurityAudit {
         #[arg(long)]
         source: String,
+        /// Triaged RUSTSEC advisory IDs to ignore
+        #[arg(long, value_delimiter = ',')]
+        ignore: Vec<String>,
+        /// Fail only on advisories at or above this CVSS severity (unscored count as high)
+        #[arg(long, value_enum, default_value_t = severity::Severity::Low)]
+        min_severity: severity::Severity,
+    },
+    /// Flag network, filesystem and process access in build.rs files
+    #[command(name = "build-script-audit")]
+    BuildScriptAudit {
+        #[arg(long)]
+        source: String,
+        /// Fail when any finding is reported
+        #[arg(long)]
+        deny: bool,
+    },
+    /// Verify a failing migration rolls back cleanly
+    #[command(name = "rollback-test")]
+    RollbackTest {
+        #[arg(long)]
+        source: String,
+        /// SQL file used as the failing migration (defaults to a built-in fixture)
+        #[arg(long)]
+        bad_migration: Option<String>,
+        /// Diesel migrations directory, relative to the source root
+        #[arg(long, default_value = "migrations")]
+        migrations_dir: String,
+    },
+    /// Clippy per crate across parallel containers
+    #[command(name = "lint-parallel")]
+    LintParallel {
+        #[arg(long)]
+        source: String,
+        /// Number of concurrent clippy containers
+        #[arg(long, default_value_t = 4)]
+        workers: usize,
+    },
+    /// Scan the source tree for committed secrets
+    #[command(name = "secret-scan")]
+    SecretScan {
+        #[arg(long)]
+        source: String,
+        /// Lowest severity that fails the scan
+        #[arg(long, value_enum, default_value = "high")]
+        min_severity: severity::Severity,
+        /// .gitleaksignore file listing fingerprints of known false positives
+        #[arg(long)]
+        allowlist: Option<String>,
+    },
+    /// Export the server's OpenAPI spec
+    #[command(name = "api-schema")]
+    ApiSchema {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "openapi.json")]
+        output: String,
+    },
+    /// Fail on breaking changes against the committed OpenAPI spec
+    #[command(name = "api-schema-diff")]
+    ApiSchemaDiff {
+        #[arg(long)]
+        source: String,
+        /// Committed baseline spec, relative to the source root
+        #[arg(long, default_value = "openapi.json")]
+        baseline: String,
+    },
+    /// Verify installing an installed module is a no-op
+    #[command(name = "install-idempotency-test")]
+    InstallIdempotencyTest {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "todo_list")]
+        module: String,
+    },
+    /// Print the digests the floating base image tags currently resolve to
+    #[command(name = "resolve-digests")]
+    ResolveDigests,
+    /// Interactive shell in the prepared build container (run under `dagger run`)
+    #[command(name = "debug-shell")]
+    DebugShell {
+        #[arg(long)]
+        source: String,
+    },
+    /// Verify dependencies are installed before their dependents
+    #[command(name = "install-order-test")]
+    InstallOrderTest {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "todo_list")]
+        module: String,
+    },
+    /// Export raw `cargo metadata` JSON
+    Metadata {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "metadata.json")]
+        output: String,
+    },
+    /// Apply `cargo audit fix` and export the updated source
+    #[command(name = "audit-fix")]
+    AuditFix {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "audit-fix")]
+        output: String,
+    },
+    /// Render every view a module declares against a running server
+    #[command(name = "view-render-test")]
+    ViewRenderTest {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "todo_list")]
+        module: String,
+        /// Server route that renders a view; `{xmlid}` is replaced by `module.view_id`
+        #[arg(long, default_value = "/api/views/{xmlid}")]
+        render_path: String,
+    },
+    /// Sample server RSS over a repeated smoke workload
+    #[command(name = "memory-profile")]
+    MemoryProfile {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value_t = 200)]
+        iterations: u32,
+        /// Request paths hit on every iteration
+        #[arg(long, value_delimiter = ',', default_value = "/health")]
+        smoke_paths: Vec<String>,
+        /// Maximum RSS growth between the first and last sample
+        #[arg(long, default_value_t = 50.0)]
+        max_growth_mb: f64,
+    },
+    /// Clippy warnings introduced relative to a base tree
+    #[command(name = "lint-diff")]
+    LintDiff {
+        #[arg(long)]
+        source: String,
+        /// Tree to compare against, e.g. a checkout of the target branch
+        #[arg(long)]
+        base_source: String,
+    },
+    /// Export a docker-compose.yml mirroring the CI Postgres + server services
+    Compose {
+        #[arg(long, default_value = "docker-compose.yml")]
+        output: String,
+    },
+    /// Line coverage with lcov, cobertura and HTML reports
+    Coverage {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "coverage")]
+        output: String,
+        /// Fail below this total line coverage percentage (0 disables)
+        #[arg(long, alias = "min-coverage", default_value_t = 0.0)]
+        min_percent: f64,
+    },
+    /// Run the full pipeline and export a JUnit XML report with one test case per phase
+    Report {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "junit.xml")]
+        output: String,
+        /// Maximum number of phases running at once
+        #[arg(long, default_value_t = 4)]
+        concurrency: usize,
+    },
+    /// Release build of erp-server, exported as a binary
+    Build {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "erp-server")]
+        output: String,
+    },
+    /// Cross-compiled release build (x86_64-unknown-linux-musl, aarch64-unknown-linux-gnu)
+    #[command(name = "build-cross")]
+    BuildCross {
+        #[arg(long)]
+        source: String,
+        #[arg(long)]
+        target: String,
+        #[arg(long, default_value = "erp-server")]
+        output: String,
+    },
+    /// Build the amd64 + arm64 runtime image and push it (requires REGISTRY_PASSWORD env)
+    #[command(name = "publish-image")]
+    PublishImage {
+        #[arg(long)]
+        source: String,
+        #[command(flatten)]
+        target: stages::publish::PublishTarget,
+        #[arg(long)]
+        tag: String,
+    },
+    /// Apply cargo fmt (and optionally clippy --fix) and export the fixed source
+    #[command(name = "fmt-fix")]
+    FmtFix {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "fmt-fix")]
+        output: String,
+        /// Also apply machine-applicable clippy suggestions
+        #[arg(long)]
+        clippy_fix: bool,
+    },
+    /// Workspace doc tests
+    #[command(name = "doc-test")]
+    DocTest {
+        #[arg(long)]
+        source: String,
+    },
+    /// cargo-deny license, ban and source checks
+    Deny {
+        #[arg(long)]
+        source: String,
+        /// deny.toml to use instead of the workspace's own
+        #[arg(long)]
+        config: Option<String>,
+    },
+    /// Check + unit tests across several Rust toolchains in parallel
+    #[command(name = "toolchain-matrix")]
+    ToolchainMatrix {
+        #[arg(long)]
+        source: String,
+        /// Rust versions and/or channels (stable, beta, nightly)
+        #[arg(long, value_delimiter = ',', default_value = "stable,beta,nightly")]
+        toolchains: Vec<String>,
+    },
+    /// cargo check on the workspace's declared rust-version
+    Msrv {
+        #[arg(long)]
+        source: String,
+    },
+    /// Install, upgrade and uninstall lifecycle test for any module
+    #[command(name = "module-lifecycle-test")]
+    ModuleLifecycleTest {
+        #[arg(long)]
+        source: String,
+        #[arg(long)]
+        module: String,
+        /// Tables the module creates, checked after install and after uninstall
+        #[arg(long, value_delimiter = ',')]
+        tables: Vec<String>,
+        #[arg(long, value_enum, default_value_t)]
+        verbosity: stages::integration::Verbosity,
+    },
+    /// Install and uninstall every module in dependency order
+    #[command(name = "all-modules-lifecycle")]
+    AllModulesLifecycle {
+        #[arg(long)]
+        source: String,
+        #[arg(long, value_enum, default_value_t)]
+        verbosity: stages::integration::Verbosity,
+    },
+    /// Integration lifecycle across several PostgreSQL versions concurrently
+    #[command(name = "pg-matrix")]
+    PgMatrix {
+        #[arg(long)]
+        source: String,
+        #[arg(long, value_delimiter = ',', default_value = "15,16,17,18")]
+        versions: Vec<String>,
+        #[arg(long, value_enum, default_value_t)]
+        verbosity: stages::integration::Verbosity,
+    },
+    /// Diesel migration up/down reversibility (migration redo) with schema drift check
+    #[command(name = "migration-test")]
+    MigrationTest {
+        #[arg(long)]
+        source: String,
+        /// Number of most recent migrations to redo
+        #[arg(long, default_value_t = 5)]
+        redo_count: u32,
+        #[arg(long, default_value = "migrations")]
+        migrations_dir: String,
+    },
+    /// Diff diesel print-schema of the migrated database against the committed schema.rs
+    #[command(name = "schema-drift")]
+    SchemaDrift {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "erp_core/src/schema.rs")]
+        schema_path: String,
+        #[arg(long, default_value = "migrations")]
+        migrations_dir: String,
+    },
+    /// Restore an old-release pg_dump, upgrade it with the new binary, and check row counts
+    #[command(name = "upgrade-test")]
+    UpgradeTest {
+        #[arg(long)]
+        source: String,
+        /// pg_dump of a previous release's database (custom or plain format)
+        #[arg(long)]
+        baseline_dump: String,
+        /// Tables whose row counts must not drop
+        #[arg(long, value_delimiter = ',', default_value = "ir_module_module,ir_model_data")]
+        tables: Vec<String>,
+    },
+    /// Run the hurl API suite against a served erp-server (login password from CI_API_PASSWORD)
+    #[command(name = "api-test")]
+    ApiTest {
+        #[arg(long)]
+        source: String,
+        /// Directory of `.hurl` files, relative to the source root
+        #[arg(long, default_value = "tests/api")]
+        suite: String,
+        /// Modules installed on top of base before the suite runs
+        #[arg(long, value_delimiter = ',', default_value = "todo_list")]
+        modules: Vec<String>,
+    },
+    /// k6 load test against a served erp-server, failing on p95 latency or error rate
+    #[command(name = "load-test")]
+    LoadTest {
+        #[arg(long)]
+        source: String,
+        /// k6 scenario to run instead of the built-in one
+        #[arg(long)]
+        script: Option<String>,
+        /// Request paths the built-in scenario hits on every iteration
+        #[arg(long, value_delimiter = ',', default_value = "/health")]
+        paths: Vec<String>,
+        #[command(flatten)]
+        load: stages::load_test::LoadOpts,
+    },
+    /// Build the erp_web/static frontend (Tailwind plus the package build script)
+    #[command(name = "frontend-build")]
+    FrontendBuild {
+        #[arg(long)]
+        source: String,
+    },
+    /// Lint the erp_web/static frontend with eslint and prettier
+    #[command(name = "frontend-lint")]
+    FrontendLint {
+        #[arg(long)]
+        source: String,
+    },
+    /// Run the erp_web/static frontend tests
+    #[command(name = "frontend-test")]
+    FrontendTest {
+        #[arg(long)]
+        source: String,
+    },
+    /// Run only the phases affected by the changes since a base ref
+    Changed {
+        #[arg(long)]
+        source: String,
+        /// Ref the current HEAD is diffed against (merge-base diff)
+        #[arg(long, default_value = "origin/main")]
+        base_ref: String,
+        /// Maximum number of phases running at once
+        #[arg(long, default_value_t = 4)]
+        concurrency: usize,
+    },
+    /// Unit tests split by crate across parallel containers
+    #[command(name = "test-sharded")]
+    TestSharded {
+        #[arg(long)]
+        source: String,
+        /// Number of concurrent shards
+        #[arg(long, default_value_t = 4)]
+        shards: usize,
+    },
+    /// Criterion benchmarks, optionally compared against an earlier run's criterion directory
+    Bench {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "criterion")]
+        output: String,
+        /// Criterion directory exported by an earlier `bench` run to compare against
+        #[arg(long)]
+        baseline: Option<String>,
+        /// Fail when a benchmark's mean time grows by more than this percentage
+        #[arg(long, default_value_t = 10.0)]
+        max_regression_percent: f64,
+    },
+    /// Trivy scan of the runtime image, failing on CRITICAL/HIGH CVEs
+    #[command(name = "image-scan")]
+    ImageScan {
+        #[arg(long)]
+        source: String,
+        /// .trivyignore-format file of allowed CVE IDs (default: the workspace's .trivyignore)
+        #[arg(long)]
+        allowlist: Option<String>,
+    },
+    /// CycloneDX/SPDX SBOMs for the workspace crates and the runtime image
+    Sbom {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "sbom")]
+        output: String,
+    },
+    /// Check module manifest dependencies for cycles and unknown modules
+    #[command(name = "module-graph")]
+    ModuleGraph {
+        #[arg(long)]
+        source: String,
+        /// Framework modules that may be depended on without a manifest in the tree
+        #[arg(long, value_delimiter = ',', default_value = "base")]
+        builtins: Vec<String>,
+        /// Export modules.dot and modules.svg to this directory
+        #[arg(long)]
+        output: Option<String>,
+    },
+    /// Lint Diesel up migrations with squawk for locking hazards
+    #[command(name = "migration-lint")]
+    MigrationLint {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "migrations")]
+        migrations_dir: String,
+        /// squawk rules to skip (e.g. prefer-robust-stmts)
+        #[arg(long, value_delimiter = ',')]
+        exclude: Vec<String>,
+    },
+    /// Find unused dependencies with cargo-udeps on nightly
+    Udeps {
+        #[arg(long)]
+        source: String,
+        /// Only list unused dependencies instead of failing
+        #[arg(long)]
+        warn_only: bool,
+    },
+    /// Build the workspace through sccache and print its cache statistics
+    #[command(name = "cache-stats")]
+    CacheStats {
+        #[arg(long)]
+        source: String,
+    },
+    /// Prebuild workspace dependencies into the cache volumes (cargo-chef), for a nightly job
+    #[command(name = "warm-cache")]
+    WarmCache {
+        #[arg(long)]
+        source: String,
+    },
+    /// Push the toolchain + system packages image for --base-image (requires REGISTRY_PASSWORD env)
+    #[command(name = "publish-base-image")]
+    PublishBaseImage {
+        #[arg(long, default_value = "ghcr.io")]
+        registry: String,
+        #[arg(long, default_value = "centrixsystems/ci-rust-base")]
+        repository: String,
+        /// Image tag (default: the Rust version, plus the channel when not stable)
+        #[arg(long)]
+        tag: Option<String>,
+        #[arg(long)]
+        username: String,
+    },
+    /// Run the full pipeline and post per-phase commit statuses and a check run, with
+    /// clippy and test failures as annotations, to GitHub. Reads the token from
+    /// GITHUB_TOKEN
+    ReportGithub {
+        #[arg(long)]
+        source: String,
+        /// Repository as owner/name
+        #[arg(long)]
+        repo: String,
+        /// Commit the statuses and check run are attached to
+        #[arg(long)]
+        sha: String,
+        /// API root; set for GitHub Enterprise Server
+        #[arg(long, default_value = "https://api.github.com")]
+        api_url: String,
+        /// Maximum number of phases running at once
+        #[arg(long, default_value_t = 4)]
+        concurrency: usize,
+    },
+    /// Export clippy and module lint findings as SARIF for GitHub code scanning
+    Sarif {
+        #[arg(long)]
+        source: String,
+        /// RelaxNG (.rng) or XSD (.xsd) schema for module XML; see `module-lint`
+        #[arg(long)]
+        schema: Option<String>,
+        #[arg(long, default_value = "results.sarif")]
+        output: String,
+    },
+    /// Post a saved `all --json` report to a Slack, Discord or Teams webhook, read from
+    /// CI_WEBHOOK_URL
+    Notify {
+        /// JSON report written by `all --json`
+        #[arg(long)]
+        report: String,
+        #[arg(long, value_enum, default_value_t = stages::notify::WebhookKind::Slack)]
+        kind: stages::notify::WebhookKind,
+        /// Link included in the message, e.g. the CI run page
+        #[arg(long)]
+        link: Option<String>,
+    },
+    /// Gate, build binaries and images, and publish a GitHub release with a changelog
+    Release {
+        #[arg(long)]
+        source: String,
+        /// Version to release, X.Y.Z[-pre], tagged as vX.Y.Z (default: `next-version`)
+        #[arg(long)]
+        version: Option<String>,
+        #[command(flatten)]
+        target: stages::release::ReleaseTarget,
+        /// Maximum number of gate phases running at once
+        #[arg(long, default_value_t = 4)]
+        concurrency: usize,
+    },
+    /// Print the next semver version from the Conventional Commits since the last tag
+    NextVersion {
+        #[arg(long)]
+        source: String,
+        /// Commits to consider instead of <last tag>..HEAD, e.g. v1.2.0..main
+        #[arg(long)]
+        range: Option<String>,
+    },
+    /// helm upgrade --install the workspace chart into staging, then smoke-check it
+    DeployStaging {
+        #[arg(long)]
+        source: String,
+        /// Chart directory in the workspace
+        #[arg(long, default_value = stages::helm::DEFAULT_CHART)]
+        chart: String,
+        #[command(flatten)]
+        release: stages::helm::HelmRelease,
+        /// Values overlay for staging
+        #[arg(long)]
+        values: Option<String>,
+        /// Kubeconfig for the staging cluster; passed to helm as a secret
+        #[arg(long)]
+        kubeconfig: String,
+        /// Base URL of the deployed server, polled at /health
+        #[arg(long)]
+        url: String,
+    },
+    /// helm lint --strict the workspace chart
+    ChartLint {
+        #[arg(long)]
+        source: String,
+        /// Chart directory in the workspace
+        #[arg(long, default_value = stages::helm::DEFAULT_CHART)]
+        chart: String,
+    },
+    /// Lint and package the workspace chart, optionally pushing it to an OCI registry
+    ChartPackage {
+        #[arg(long)]
+        source: String,
+        /// Chart directory in the workspace
+        #[arg(long, default_value = stages::helm::DEFAULT_CHART)]
+        chart: String,
+        /// Override the chart version
+        #[arg(long)]
+        version: Option<String>,
+        /// Override the chart appVersion
+        #[arg(long)]
+        app_version: Option<String>,
+        /// Directory the packaged chart is exported to
+        #[arg(long, default_value = "charts")]
+        output: String,
+        #[command(flatten)]
+        push: stages::helm::ChartPush,
+    },
+    /// Build the workspace docs with rustdoc warnings denied and export them
+    Docs {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "docs")]
+        output: String,
+    },
+    /// Generate the OpenAPI spec, validate it and fail on breaking changes against the
+    /// committed one
+    #[command(name = "openapi")]
+    OpenApi {
+        #[arg(long)]
+        source: String,
+        /// Committed baseline spec, relative to the source root
+        #[arg(long, default_value = "openapi.json")]
+        baseline: String,
+        /// Also export the generated spec here
+        #[arg(long)]
+        output: Option<String>,
+    },
+    /// Module lifecycles as concurrent scenarios, each against its own PostgreSQL
+    Scenarios {
+        #[arg(long)]
+        source: String,
+        /// Modules whose lifecycle runs as a scenario
+        #[arg(long, value_delimiter = ',', default_value = "todo_list")]
+        modules: Vec<String>,
+        /// Maximum number of scenarios running at once
+        #[arg(long, default_value_t = 4)]
+        concurrency: usize,
+        #[arg(long, value_enum, default_value_t)]
+        verbosity: stages::integration::Verbosity,
+    },
+    /// Verify seeding an already seeded database is a no-op
+    #[command(name = "seed-idempotency-test")]
+    SeedIdempotencyTest {
+        #[arg(long)]
+        source: String,
+    },
+    /// Back up the database after the lifecycle and verify the restore round-trip
+    #[command(name = "backup-restore-test")]
+    BackupRestoreTest {
+        #[arg(long)]
+        source: String,
+        /// Tables whose row counts must survive the round-trip
+        #[arg(long, value_delimiter = ',', default_value = "ir_model_data")]
+        tables: Vec<String>,
+    },
+    /// pgTAP tests run with pg_prove against the migrated schema
+    #[command(name = "sql-test")]
+    SqlTest {
+        #[arg(long)]
+        source: String,
+        /// Directory of pgTAP `.sql` tests, relative to the source root
+        #[arg(long, default_value = "tests/sql")]
+        tests: String,
+    },
+    /// Playwright browser flows through erp_web against a served erp-server
+    #[command(name = "web-e2e")]
+    WebE2e {
+        #[arg(long)]
+        source: String,
+        /// Playwright project directory, relative to the source root
+        #[arg(long, default_value = "erp_web/e2e")]
+        suite: String,
+        /// Modules installed on top of base before the flows run
+        #[arg(long, value_delimiter = ',', default_value = "todo_list")]
+        modules: Vec<String>,
+        /// Directory traces and screenshots are exported to when a flow fails
+        #[arg(long, default_value = "e2e-results")]
+        artifacts: String,
+    },
+    /// Run the unit tests repeatedly and report intermittently failing tests
+    #[command(name = "flaky-hunt")]
+    FlakyHunt {
+        #[arg(long)]
+        source: String,
+        #[command(flatten)]
+        hunt: stages::flaky::HuntOpts,
+        /// Packages to test; the whole workspace when empty
+        #[arg(long, value_delimiter = ',')]
+        packages: Vec<String>,
+    },
+    /// Compare unit test results in declaration order and shuffled from a seed
+    #[command(name = "test-order")]
+    TestOrder {
+        #[arg(long)]
+        source: String,
+        /// Shuffle seed; fresh (and printed) when unset
+        #[arg(long)]
+        seed: Option<u64>,
+        /// libtest thread count of both runs
+        #[arg(long, default_value_t = 1)]
+        threads: usize,
+        /// Packages to test; the whole workspace when empty
+        #[arg(long, value_delimiter = ',')]
+        packages: Vec<String>,
+    },
+    /// Unit tests of selected crates under Miri to detect undefined behavior
+    Miri {
+        #[arg(long)]
+        source: String,
+        /// Crates whose unit tests run under Miri
+        #[arg(long, value_delimiter = ',', default_value = "erp_core")]
+        packages: Vec<String>,
+        /// Passed to Miri as MIRIFLAGS
+        #[arg(long, default_value = "")]
+        miri_flags: String,
+    },
+    /// Unit tests built with a sanitizer on nightly (extended nightly pipeline)
+    Sanitize {
+        #[arg(long)]
+        source: String,
+        #[arg(long, value_enum)]
+        sanitizer: stages::sanitize::Sanitizer,
+        /// Packages to test; the whole workspace when empty
+        #[arg(long, value_delimiter = ',')]
+        packages: Vec<String>,
+    },
+    /// libFuzzer run of a cargo-fuzz target over a cached corpus
+    Fuzz {
+        #[arg(long)]
+        source: String,
+        /// cargo-fuzz target under fuzz/fuzz_targets
+        #[arg(long)]
+        target: String,
+        #[arg(long, default_value_t = 300)]
+        seconds: u64,
+        /// Directory crash artifacts are exported to
+        #[arg(long, default_value = "fuzz-artifacts")]
+        output: String,
+    },
+    /// Mutation testing of a package with cargo-mutants, reporting surviving mutants
+    Mutants {
+        #[arg(long)]
+        source: String,
+        #[arg(long, default_value = "erp_core")]
+        package: String,
+        /// Total time budget in seconds; mutants not reached by then are skipped
+        #[arg(long, default_value_t = 3600)]
+        budget_secs: u64,
+        /// Timeout of each mutant's test run in seconds
+        #[arg(long, default_value_t = 300)]
+        test_timeout_secs: u64,
     },
-    /// Full pipeline (check + fmt + lint + test + module-lint + integration)
+    /// Unit tests with many times the default property-test cases (nightly pipeline)
+    #[command(name = "test-extended")]
+    TestExtended {
+        #[arg(long)]
+        source: String,
+        /// Multiple of the default proptest and quickcheck case counts
+        #[arg(long, default_value_t = 100)]
+        multiplier: u32,
+        /// proptest RNG seed; a fresh seed is picked (and printed) when unset
+        #[arg(long)]
+        proptest_seed: Option<u64>,
+        /// Export the nextest JUnit XML report to this path
+        #[arg(long)]
+        junit_output: Option<String>,
+    },
+    /// Full pipeline: Rust checks, tests, audits, integration, and the frontend phases
     All {
         #[arg(long)]
         source: String,
+        /// Maximum number of phases running at once
+        #[arg(long, default_value_t = 4)]
+        concurrency: usize,
+        /// Abort on the first failing phase instead of reporting every failure
+        #[arg(long)]
+        fail_fast: bool,
+        /// Print a JSON report (phases, durations, pass/fail, log excerpts) instead of text
+        #[arg(long)]
+        json: bool,
+        /// Run only these phases (default: all)
+        #[arg(long, value_enum, value_delimiter = ',')]
+        phases: Vec<pipeline::Phase>,
+        /// Leave out these phases, e.g. `--skip integration,security-audit` for pre-commit
+        #[arg(long, value_enum, value_delimiter = ',')]
+        skip: Vec<pipeline::Phase>,
+        /// Post the result to the webhook in CI_WEBHOOK_URL; see `notify`
+        #[arg(long, value_enum)]
+        notify: Option<stages::notify::WebhookKind>,
+        /// Link included in the notification, e.g. the CI run page
+        #[arg(long)]
+        link: Option<String>,
+        /// Export each phase's complete output as `<phase>.log` into this directory
+        #[arg(long)]
+        logs: Option<String>,
+        /// Export a self-contained HTML report of the run to this path
+        #[arg(long)]
+        html: Option<String>,
+        /// lcov tracefile whose line coverage the `--html` report summarizes
+        #[arg(long, requires = "html")]
+        coverage: Option<String>,
     },
 }
 
+/// Only the `.git` directory of the checkout at `source`, for history and diffs.
+fn git_directory(client: &Query, source: &str) -> Directory {
+    client.host().directory_opts(
+        source,
+        HostDirectoryOpts {
+            exclude: None,
+            include: Some(vec![".git/"]),
+            gitignore: None,
+            no_cache: None,
+        },
+    )
+}
+
 fn host_directory(client: &Query, source: &str) -> Directory {
     client.host().directory_opts(
         source,
@@ -91,78 +878,553 @@ fn host_directory(client: &Query, source: &str) -> Directory {
 #[tokio::main]
 async fn main() -> eyre::Result<()> {
     color_eyre::install()?;
-    let Cli { command } = Cli::parse();
+    let Cli { base, command } = Cli::parse();
+    base.validate()?;
 
     dagger_sdk::connect(|client| async move {
         match command {
             Command::Check { source } => {
                 let src = host_directory(&client, &source);
-                let out = stages::check::run(&client, src).await?;
+                let out = stages::check::run(&client, src, &base).await?;
                 println!("{out}");
             }
             Command::Fmt { source } => {
                 let src = host_directory(&client, &source);
-                let out = stages::fmt::run(&client, src).await?;
+                let out = stages::fmt::run(&client, src, &base).await?;
                 println!("{out}");
             }
             Command::Lint { source } => {
                 let src = host_directory(&client, &source);
-                let out = stages::lint::run(&client, src).await?;
+                let out = stages::lint::run(&client, src, &base).await?;
                 println!("{out}");
             }
-            Command::Test { source } => {
+            Command::Test { source, proptest, junit_output } => {
                 let src = host_directory(&client, &source);
-                let out = stages::test::run(&client, src).await?;
+                let out =
+                    stages::test::run(&client, src, &base, &proptest, &[], junit_output.as_deref())
+                        .await?;
                 println!("{out}");
             }
-            Command::IntegrationTest { source } => {
+            Command::IntegrationTest { source, verbosity, services, fixtures } => {
                 let src = host_directory(&client, &source);
-                let out = stages::integration::run(&client, src).await?;
+                let fixtures = fixtures.map(|path| client.host().directory(path));
+                let out = stages::integration::run(
+                    &client, src, verbosity, &services, fixtures, &base,
+                )
+                .await?;
                 println!("{out}");
             }
-            Command::ModuleLint { source } => {
+            Command::CockroachTest { source } => {
                 let src = host_directory(&client, &source);
-                let out = stages::module_lint::run(&client, src).await?;
+                let out = stages::cockroach::run(&client, src, &base).await?;
                 println!("{out}");
             }
+            Command::ModuleLint { source, schema, json } => {
+                let src = host_directory(&client, &source);
+                let schema = schema.map(|path| client.host().file(path));
+                if json {
+                    let report = stages::module_lint::lint(&client, &src, schema, &base).await?;
+                    println!("{}", report.to_json()?);
+                    if report.errors > 0 {
+                        return Err(eyre::eyre!("[module-lint] {} error(s)", report.errors));
+                    }
+                } else {
+                    println!("{}", stages::module_lint::run(&client, src, schema, &base).await?);
+                }
+            }
             Command::TailwindBuild { source } => {
                 let src = host_directory(&client, &source);
-                let out = stages::tailwind::run(&client, src).await?;
+                let out = stages::tailwind::run(&client, src, &base).await?;
                 println!("{out}");
             }
             Command::Deploy { source, host } => {
                 let src = host_directory(&client, &source);
-                let out = stages::deploy::run(&client, src, &host).await?;
+                let out = stages::deploy::run(&client, src, &host, &base).await?;
                 println!("{out}");
             }
-            Command::SecurityAudit { source } => {
+            Command::SecurityAudit { source, ignore, min_severity } => {
                 let src = host_directory(&client, &source);
-                let out = stages::security::run(&client, src).await?;
+                let out =
+                    stages::security::run(&client, src, &ignore, min_severity, &base).await?;
                 println!("{out}");
             }
-            Command::All { source } => {
+            Command::BuildScriptAudit { source, deny } => {
                 let src = host_directory(&client, &source);
-
-                println!("=== Phase 1: Fast Gates ===");
-                let (check_out, fmt_out) = tokio::try_join!(
-                    stages::check::run(&client, src.clone()),
-                    stages::fmt::run(&client, src.clone()),
-                )?;
-                println!("{check_out}\n{fmt_out}");
-
-                println!("=== Phase 2: Quality Gates ===");
-                let (lint_out, test_out, mlint_out) = tokio::try_join!(
-                    stages::lint::run(&client, src.clone()),
-                    stages::test::run(&client, src.clone()),
-                    stages::module_lint::run(&client, src.clone()),
-                )?;
-                println!("{lint_out}\n{test_out}\n{mlint_out}");
-
-                println!("=== Phase 3: Integration ===");
-                let int_out = stages::integration::run(&client, src).await?;
-                println!("{int_out}");
-
-                println!("\n=== Full CI Pipeline Complete ===");
+                let out = stages::build_script_audit::run(src, deny).await?;
+                println!("{out}");
+            }
+            Command::RollbackTest { source, bad_migration, migrations_dir } => {
+                let src = host_directory(&client, &source);
+                let bad = bad_migration.map(|path| client.host().file(path));
+                let out = stages::rollback::run(&client, src, bad, &migrations_dir, &base).await?;
+                println!("{out}");
+            }
+            Command::LintParallel { source, workers } => {
+                let src = host_directory(&client, &source);
+                let out = stages::lint_parallel::run(&client, src, workers, &base).await?;
+                println!("{out}");
+            }
+            Command::SecretScan { source, min_severity, allowlist } => {
+                let src = host_directory(&client, &source);
+                let allowlist = allowlist.map(|path| client.host().file(path));
+                let out = stages::secret_scan::run(&client, src, min_severity, allowlist).await?;
+                println!("{out}");
+            }
+            Command::ApiSchema { source, output } => {
+                let src = host_directory(&client, &source);
+                let out = stages::api_schema::run(&client, src, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::ApiSchemaDiff { source, baseline } => {
+                let src = host_directory(&client, &source);
+                let out = stages::api_schema::diff(&client, src, &baseline, &base).await?;
+                println!("{out}");
+            }
+            Command::InstallIdempotencyTest { source, module } => {
+                let src = host_directory(&client, &source);
+                let out = stages::idempotency::run(&client, src, &module, &base).await?;
+                println!("{out}");
+            }
+            Command::ResolveDigests => {
+                let out = containers::resolve_digests(&client, &base).await?;
+                println!("{out}");
+            }
+            Command::DebugShell { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::debug_shell::run(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::InstallOrderTest { source, module } => {
+                let src = host_directory(&client, &source);
+                let out = stages::install_order::run(&client, src, &module, &base).await?;
+                println!("{out}");
+            }
+            Command::Metadata { source, output } => {
+                let src = host_directory(&client, &source);
+                let out = metadata::run(&client, src, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::AuditFix { source, output } => {
+                let src = host_directory(&client, &source);
+                let out = stages::audit_fix::run(&client, src, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::ViewRenderTest { source, module, render_path } => {
+                let src = host_directory(&client, &source);
+                let out =
+                    stages::view_render::run(&client, src, &module, &render_path, &base).await?;
+                println!("{out}");
+            }
+            Command::MemoryProfile { source, iterations, smoke_paths, max_growth_mb } => {
+                let src = host_directory(&client, &source);
+                let out = stages::memory_profile::run(
+                    &client, src, iterations, &smoke_paths, max_growth_mb, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::LintDiff { source, base_source } => {
+                let src = host_directory(&client, &source);
+                let base_src = host_directory(&client, &base_source);
+                let out = stages::lint_diff::run(&client, src, base_src, &base).await?;
+                println!("{out}");
+            }
+            Command::Compose { output } => {
+                let out = stages::compose::run(&client, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::Coverage { source, output, min_percent } => {
+                let src = host_directory(&client, &source);
+                let out = stages::coverage::run(&client, src, &output, min_percent, &base).await?;
+                println!("{out}");
+            }
+            Command::Report { source, output, concurrency } => {
+                let src = host_directory(&client, &source);
+                let out = pipeline::report(&client, src, &output, concurrency, &base).await?;
+                println!("{out}");
+            }
+            Command::Build { source, output } => {
+                let src = host_directory(&client, &source);
+                let out = stages::build::run(&client, src, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::BuildCross { source, target, output } => {
+                let src = host_directory(&client, &source);
+                let out = stages::build_cross::run(&client, src, &target, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::PublishImage { source, target, tag } => {
+                let src = host_directory(&client, &source);
+                let out = stages::publish::run(&client, src, &target, &tag, &base).await?;
+                println!("{out}");
+            }
+            Command::FmtFix { source, output, clippy_fix } => {
+                let src = host_directory(&client, &source);
+                let out = stages::fmt::fix(&client, src, clippy_fix, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::DocTest { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::doc_test::run(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::Deny { source, config } => {
+                let src = host_directory(&client, &source);
+                let config = config.map(|path| client.host().file(path));
+                let out = stages::deny::run(&client, src, config, &base).await?;
+                println!("{out}");
+            }
+            Command::ToolchainMatrix { source, toolchains } => {
+                let src = host_directory(&client, &source);
+                let out = stages::toolchain_matrix::run(&client, src, &toolchains, &base).await?;
+                println!("{out}");
+            }
+            Command::Msrv { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::msrv::run(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::ModuleLifecycleTest { source, module, tables, verbosity } => {
+                let src = host_directory(&client, &source);
+                let out = stages::module_lifecycle::run(
+                    &client, src, &module, &tables, verbosity, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::AllModulesLifecycle { source, verbosity } => {
+                let src = host_directory(&client, &source);
+                let out = stages::all_modules::run(&client, src, verbosity, &base).await?;
+                println!("{out}");
+            }
+            Command::PgMatrix { source, versions, verbosity } => {
+                let src = host_directory(&client, &source);
+                let out = stages::pg_matrix::run(&client, src, &versions, verbosity, &base).await?;
+                println!("{out}");
+            }
+            Command::MigrationTest { source, redo_count, migrations_dir } => {
+                let src = host_directory(&client, &source);
+                let out =
+                    stages::migration::run(&client, src, redo_count, &migrations_dir, &base)
+                        .await?;
+                println!("{out}");
+            }
+            Command::SchemaDrift { source, schema_path, migrations_dir } => {
+                let src = host_directory(&client, &source);
+                let out =
+                    stages::schema_drift::run(&client, src, &schema_path, &migrations_dir, &base)
+                        .await?;
+                println!("{out}");
+            }
+            Command::UpgradeTest { source, baseline_dump, tables } => {
+                let src = host_directory(&client, &source);
+                let dump = client.host().file(baseline_dump);
+                let out = stages::upgrade::run(&client, src, dump, &tables, &base).await?;
+                println!("{out}");
+            }
+            Command::ApiTest { source, suite, modules } => {
+                let src = host_directory(&client, &source);
+                let out = stages::api_test::run(&client, src, &suite, &modules, &base).await?;
+                println!("{out}");
+            }
+            Command::LoadTest { source, script, paths, load } => {
+                let src = host_directory(&client, &source);
+                let script = script.map(|path| client.host().file(path));
+                let out =
+                    stages::load_test::run(&client, src, script, &paths, &load, &base).await?;
+                println!("{out}");
+            }
+            Command::FrontendBuild { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::frontend::build(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::FrontendLint { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::frontend::lint(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::FrontendTest { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::frontend::test(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::Changed { source, base_ref, concurrency } => {
+                let src = host_directory(&client, &source);
+                let repo = git_directory(&client, &source);
+                let out =
+                    stages::changed::run(&client, src, repo, &base_ref, concurrency, &base).await?;
+                println!("{out}");
+            }
+            Command::TestSharded { source, shards } => {
+                let src = host_directory(&client, &source);
+                let out = stages::test_sharded::run(&client, src, shards, &base).await?;
+                println!("{out}");
+            }
+            Command::Bench { source, output, baseline, max_regression_percent } => {
+                let src = host_directory(&client, &source);
+                let baseline = baseline.map(|path| client.host().directory(path));
+                let out = stages::bench::run(
+                    &client, src, &output, baseline, max_regression_percent, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::ImageScan { source, allowlist } => {
+                let src = host_directory(&client, &source);
+                let allowlist = allowlist.map(|path| client.host().file(path));
+                let out = stages::image_scan::run(&client, src, allowlist, &base).await?;
+                println!("{out}");
+            }
+            Command::Sbom { source, output } => {
+                let src = host_directory(&client, &source);
+                let out = stages::sbom::run(&client, src, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::ModuleGraph { source, builtins, output } => {
+                let src = host_directory(&client, &source);
+                let out = stages::module_graph::run(
+                    &client, src, &builtins, output.as_deref(), &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::MigrationLint { source, migrations_dir, exclude } => {
+                let src = host_directory(&client, &source);
+                let out = stages::migration_lint::run(
+                    &client, src, &migrations_dir, &exclude, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::Udeps { source, warn_only } => {
+                let src = host_directory(&client, &source);
+                let out = stages::udeps::run(&client, src, warn_only, &base).await?;
+                println!("{out}");
+            }
+            Command::CacheStats { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::cache_stats::run(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::WarmCache { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::warm_cache::run(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::PublishBaseImage { registry, repository, tag, username } => {
+                let out = stages::publish_base::run(
+                    &client, &registry, &repository, tag.as_deref(), &username, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::ReportGithub { source, repo, sha, api_url, concurrency } => {
+                let src = host_directory(&client, &source);
+                let out = stages::report_github::run(
+                    &client, src, &repo, &sha, &api_url, concurrency, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::Sarif { source, schema, output } => {
+                let src = host_directory(&client, &source);
+                let schema = schema.map(|path| client.host().file(path));
+                let out = stages::sarif::run(&client, src, schema, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::Notify { report, kind, link } => {
+                let report = client.host().file(report).contents().await?;
+                let out = stages::notify::run(&client, &report, kind, link.as_deref()).await?;
+                println!("{out}");
+            }
+            Command::Release { source, version, target, concurrency } => {
+                let src = host_directory(&client, &source);
+                let repo = git_directory(&client, &source);
+                let out = stages::release::run(
+                    &client, src, repo, version.as_deref(), &target, concurrency, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::NextVersion { source, range } => {
+                let repo = git_directory(&client, &source);
+                println!("{}", stages::next_version::run(&client, repo, range.as_deref()).await?);
+            }
+            Command::DeployStaging { source, chart, release, values, kubeconfig, url } => {
+                let src = host_directory(&client, &source);
+                let values = values.map(|path| client.host().file(path));
+                let kubeconfig = client.host().file(kubeconfig).contents().await?;
+                let kubeconfig = client.set_secret("kubeconfig", kubeconfig);
+                let out = stages::helm::deploy_staging(
+                    &client, src, &chart, &release, values, kubeconfig, &url,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::ChartLint { source, chart } => {
+                let src = host_directory(&client, &source);
+                println!("{}", stages::helm::lint(&client, src, &chart).await?);
+            }
+            Command::ChartPackage { source, chart, version, app_version, output, push } => {
+                let src = host_directory(&client, &source);
+                let out = stages::helm::package(
+                    &client,
+                    src,
+                    &chart,
+                    version.as_deref(),
+                    app_version.as_deref(),
+                    &output,
+                    &push,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::Docs { source, output } => {
+                let src = host_directory(&client, &source);
+                println!("{}", stages::docs::run(&client, src, &output, &base).await?);
+            }
+            Command::OpenApi { source, baseline, output } => {
+                let src = host_directory(&client, &source);
+                let out =
+                    stages::api_schema::gate(&client, src, &baseline, output.as_deref(), &base)
+                        .await?;
+                println!("{out}");
+            }
+            Command::Scenarios { source, modules, concurrency, verbosity } => {
+                let src = host_directory(&client, &source);
+                let out = stages::scenarios::run(
+                    &client, src, &modules, concurrency, verbosity, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::SeedIdempotencyTest { source } => {
+                let src = host_directory(&client, &source);
+                let out = stages::seed_idempotency::run(&client, src, &base).await?;
+                println!("{out}");
+            }
+            Command::BackupRestoreTest { source, tables } => {
+                let src = host_directory(&client, &source);
+                let out = stages::backup_restore::run(&client, src, &tables, &base).await?;
+                println!("{out}");
+            }
+            Command::SqlTest { source, tests } => {
+                let src = host_directory(&client, &source);
+                let out = stages::sql_test::run(&client, src, &tests, &base).await?;
+                println!("{out}");
+            }
+            Command::WebE2e { source, suite, modules, artifacts } => {
+                let src = host_directory(&client, &source);
+                let out =
+                    stages::web_e2e::run(&client, src, &suite, &modules, &artifacts, &base)
+                        .await?;
+                println!("{out}");
+            }
+            Command::FlakyHunt { source, hunt, packages } => {
+                let src = host_directory(&client, &source);
+                let out = stages::flaky::run(&client, src, &hunt, &packages, &base).await?;
+                println!("{out}");
+            }
+            Command::TestOrder { source, seed, threads, packages } => {
+                let src = host_directory(&client, &source);
+                let out =
+                    stages::test_order::run(&client, src, seed, threads, &packages, &base).await?;
+                println!("{out}");
+            }
+            Command::Miri { source, packages, miri_flags } => {
+                let src = host_directory(&client, &source);
+                let out = stages::miri::run(&client, src, &packages, &miri_flags, &base).await?;
+                println!("{out}");
+            }
+            Command::Sanitize { source, sanitizer, packages } => {
+                let src = host_directory(&client, &source);
+                let out = stages::sanitize::run(&client, src, sanitizer, &packages, &base).await?;
+                println!("{out}");
+            }
+            Command::Fuzz { source, target, seconds, output } => {
+                let src = host_directory(&client, &source);
+                let out =
+                    stages::fuzz::run(&client, src, &target, seconds, &output, &base).await?;
+                println!("{out}");
+            }
+            Command::Mutants { source, package, budget_secs, test_timeout_secs } => {
+                let src = host_directory(&client, &source);
+                let out = stages::mutants::run(
+                    &client, src, &package, budget_secs, test_timeout_secs, &base,
+                )
+                .await?;
+                println!("{out}");
+            }
+            Command::TestExtended { source, multiplier, proptest_seed, junit_output } => {
+                let src = host_directory(&client, &source);
+                let junit = junit_output.as_deref();
+                let out =
+                    stages::test::extended(&client, src, multiplier, proptest_seed, junit, &base)
+                        .await?;
+                println!("{out}");
+            }
+            Command::All {
+                source, concurrency, fail_fast, json, phases, skip, notify, link, logs, html,
+                coverage,
+            } => {
+                let src = host_directory(&client, &source);
+                let phases = pipeline::Phase::select(&phases, &skip);
+                if phases.is_empty() {
+                    return Err(eyre::eyre!("[all] --phases and --skip leave nothing to run"));
+                }
+                // Read before the run, so a bad path fails fast instead of losing the results.
+                let lcov = match &coverage {
+                    Some(path) => Some(
+                        client
+                            .host()
+                            .file(path)
+                            .contents()
+                            .await
+                            .map_err(|e| eyre::eyre!("[all] cannot read --coverage {path}: {e}"))?,
+                    ),
+                    None => None,
+                };
+                let results =
+                    pipeline::execute(&client, src, &phases, &[], concurrency, fail_fast, &base)
+                        .await;
+                let report = pipeline::CiReport::new(&results, phases.len());
+                if let Some(dir) = &logs {
+                    pipeline::logs(&client, &results).export(dir).await?;
+                    eprintln!("[all] Phase logs exported to {dir}.");
+                }
+                if let Some(path) = &html {
+                    let page = pipeline::html(&results, phases.len(), lcov.as_deref());
+                    client
+                        .directory()
+                        .with_new_file("report.html", page)
+                        .file("report.html")
+                        .export(path)
+                        .await?;
+                    eprintln!("[all] HTML report exported to {path}.");
+                }
+                // Status goes to stderr so `--json` output stays parseable; a failed
+                // notification is reported after the results rather than hiding them.
+                let mut notified = Ok(());
+                if let Some(kind) = notify {
+                    let link = link.as_deref();
+                    match stages::notify::send(&client, &report, kind, link).await {
+                        Ok(sent) => eprintln!("{sent}"),
+                        Err(err) => {
+                            eprintln!("{err}");
+                            notified = Err(eyre::eyre!("[all] notification failed"));
+                        }
+                    }
+                }
+                if json {
+                    println!("{}", report.to_json()?);
+                    if !report.passed {
+                        return Err(eyre::eyre!("[all] pipeline failed"));
+                    }
+                } else {
+                    println!("{}", pipeline::summary(&results, phases.len())?);
+                }
+                notified?;
             }
         }
         Ok(())
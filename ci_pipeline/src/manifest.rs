@@ -0,0 +1,81 @@
+use std::collections::BTreeMap;
+
+use dagger_sdk::Directory;
+use serde::Deserialize;
+
+/// A module's `manifest.toml`.
+#[derive(Debug, Deserialize)]
+pub struct Manifest {
+    pub module: ModuleSection,
+}
+
+/// The `[module]` table of a manifest.
+#[derive(Debug, Deserialize)]
+pub struct ModuleSection {
+    pub name: String,
+    #[serde(default, alias = "dependencies")]
+    pub depends: Vec<String>,
+}
+
+/// Parse every `modules/*/manifest.toml` in `source`, keyed by module name.
+pub async fn load_all(source: &Directory) -> eyre::Result<BTreeMap<String, Manifest>> {
+    let mut manifests = BTreeMap::new();
+    for path in source.glob("modules/*/manifest.toml").await? {
+        let text = source.file(path.as_str()).contents().await?;
+        let manifest: Manifest =
+            toml::from_str(&text).map_err(|e| eyre::eyre!("{path}: {e}"))?;
+        manifests.insert(manifest.module.name.clone(), manifest);
+    }
+    Ok(manifests)
+}
+
+/// `module` preceded by its transitive dependencies in install order (dependencies first).
+/// Dependencies without a manifest in the tree (framework built-ins) are treated as leaves.
+pub fn install_order(
+    manifests: &BTreeMap<String, Manifest>,
+    module: &str,
+) -> eyre::Result<Vec<String>> {
+    fn visit(
+        name: &str,
+        manifests: &BTreeMap<String, Manifest>,
+        stack: &mut Vec<String>,
+        order: &mut Vec<String>,
+    ) -> eyre::Result<()> {
+        if order.iter().any(|m| m == name) {
+            return Ok(());
+        }
+        if stack.iter().any(|m| m == name) {
+            return Err(eyre::eyre!(
+                "dependency cycle: {} -> {name}",
+                stack.join(" -> ")
+            ));
+        }
+
+        stack.push(name.to_string());
+        if let Some(manifest) = manifests.get(name) {
+            for dep in &manifest.module.depends {
+                visit(dep, manifests, stack, order)?;
+            }
+        }
+        stack.pop();
+        order.push(name.to_string());
+        Ok(())
+    }
+
+    let mut order = Vec::new();
+    visit(module, manifests, &mut Vec::new(), &mut order)?;
+    Ok(order)
+}
+
+/// Every module with a manifest, each after its dependencies.
+pub fn install_order_all(manifests: &BTreeMap<String, Manifest>) -> eyre::Result<Vec<String>> {
+    let mut order: Vec<String> = Vec::new();
+    for name in manifests.keys() {
+        for module in install_order(manifests, name)? {
+            if manifests.contains_key(&module) && !order.contains(&module) {
+                order.push(module);
+            }
+        }
+    }
+    Ok(order)
+}
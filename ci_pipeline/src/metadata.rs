@@ -0,0 +1,110 @@
+use std::collections::BTreeMap;
+
+use dagger_sdk::{Directory, File, Query};
+use serde::Deserialize;
+
+use crate::containers::{self, BaseOpts};
+
+/// Typed subset of `cargo metadata --format-version 1`.
+#[derive(Debug, Deserialize)]
+pub struct Workspace {
+    pub packages: Vec<Package>,
+    pub workspace_members: Vec<String>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct Package {
+    pub id: String,
+    pub name: String,
+    pub version: String,
+    pub manifest_path: String,
+    pub dependencies: Vec<Dependency>,
+    pub targets: Vec<Target>,
+    pub features: BTreeMap<String, Vec<String>>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct Dependency {
+    pub name: String,
+    pub kind: Option<String>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct Target {
+    pub name: String,
+    pub kind: Vec<String>,
+}
+
+impl Workspace {
+    /// Packages that are workspace members, in metadata order.
+    pub fn members(&self) -> impl Iterator<Item = &Package> {
+        self.packages
+            .iter()
+            .filter(|p| self.workspace_members.contains(&p.id))
+    }
+}
+
+impl Package {
+    /// Whether the crate has a library (or proc-macro) target.
+    pub fn has_lib(&self) -> bool {
+        self.targets
+            .iter()
+            .any(|t| t.kind.iter().any(|k| k.ends_with("lib") || k == "proc-macro"))
+    }
+}
+
+/// Raw `cargo metadata` JSON for `source`. Dagger caches the exec per source
+/// tree, so every function that needs the workspace model shares one computation.
+pub fn file(client: &Query, source: Directory, opts: &BaseOpts) -> File {
+    containers::rust_base(client, source, opts)
+        .with_exec(vec![
+            "sh", "-c",
+            "cargo metadata --format-version 1 > /tmp/metadata.json",
+        ])
+        .file("/tmp/metadata.json")
+}
+
+/// Parsed workspace model for `source`.
+pub async fn load(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<Workspace> {
+    let json = file(client, source, opts).contents().await?;
+    Ok(serde_json::from_str(&json)?)
+}
+
+/// Export the raw metadata JSON to `output` on the host and summarize the workspace.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let file = file(client, source, opts);
+    file.export(output).await?;
+    let workspace: Workspace = serde_json::from_str(&file.contents().await?)?;
+
+    let member_names: Vec<&str> = workspace.members().map(|p| p.name.as_str()).collect();
+    let mut summary = String::new();
+    for pkg in workspace.members() {
+        let normal_deps = pkg.dependencies.iter().filter(|d| d.kind.is_none()).count();
+        let internal: Vec<&str> = pkg
+            .dependencies
+            .iter()
+            .map(|d| d.name.as_str())
+            .filter(|name| member_names.contains(name))
+            .collect();
+        let targets: Vec<String> = pkg
+            .targets
+            .iter()
+            .map(|t| format!("{}({})", t.name, t.kind.join("/")))
+            .collect();
+        summary.push_str(&format!(
+            "  {} {}: {normal_deps} deps, {} features, targets {}, workspace deps [{}]\n",
+            pkg.name,
+            pkg.version,
+            pkg.features.len(),
+            targets.join(" "),
+            internal.join(", ")
+        ));
+    }
+
+    Ok(format!("[metadata] cargo metadata exported to {output}.\n{summary}"))
+}
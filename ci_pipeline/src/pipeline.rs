@@ -0,0 +1,464 @@
+use std::time::{Duration, Instant};
+
+use clap::ValueEnum;
+use dagger_sdk::{Directory, Query};
+use futures::stream::{self, StreamExt};
+use quick_xml::escape::escape;
+use serde::{Deserialize, Serialize};
+
+use crate::containers::BaseOpts;
+use crate::severity::Severity;
+use crate::stages;
+
+/// A phase of the full pipeline. Phases are independent of each other and report in
+/// declaration order.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, ValueEnum)]
+pub enum Phase {
+    Check,
+    Fmt,
+    Lint,
+    Test,
+    DocTest,
+    ModuleLint,
+    SecurityAudit,
+    Integration,
+    FrontendBuild,
+    FrontendLint,
+    FrontendTest,
+}
+
+impl Phase {
+    pub const ALL: [Phase; 11] = [
+        Phase::Check,
+        Phase::Fmt,
+        Phase::Lint,
+        Phase::Test,
+        Phase::DocTest,
+        Phase::ModuleLint,
+        Phase::SecurityAudit,
+        Phase::Integration,
+        Phase::FrontendBuild,
+        Phase::FrontendLint,
+        Phase::FrontendTest,
+    ];
+
+    pub fn name(self) -> &'static str {
+        match self {
+            Phase::Check => "check",
+            Phase::Fmt => "fmt",
+            Phase::Lint => "lint",
+            Phase::Test => "test",
+            Phase::DocTest => "doc-test",
+            Phase::ModuleLint => "module-lint",
+            Phase::SecurityAudit => "security-audit",
+            Phase::Integration => "integration",
+            Phase::FrontendBuild => "frontend-build",
+            Phase::FrontendLint => "frontend-lint",
+            Phase::FrontendTest => "frontend-test",
+        }
+    }
+
+    /// `ALL` narrowed to `only` (every phase when empty) minus `skip`, in `ALL` order.
+    pub fn select(only: &[Phase], skip: &[Phase]) -> Vec<Phase> {
+        Phase::ALL
+            .into_iter()
+            .filter(|p| only.is_empty() || only.contains(p))
+            .filter(|p| !skip.contains(p))
+            .collect()
+    }
+
+    /// Run the phase; `test_packages` narrows `Test` to those crates when non-empty. Also
+    /// returns the failing tests named by the `Test` phase's JUnit report.
+    async fn run(
+        self,
+        client: &Query,
+        source: Directory,
+        test_packages: &[String],
+        opts: &BaseOpts,
+    ) -> (eyre::Result<String>, Vec<String>) {
+        let result = match self {
+            Phase::Check => stages::check::run(client, source, opts).await,
+            Phase::Fmt => stages::fmt::run(client, source, opts).await,
+            Phase::Lint => stages::lint::run(client, source, opts).await,
+            Phase::Test => {
+                let proptest = Default::default();
+                let tested =
+                    stages::test::run_with_junit(client, source, opts, &proptest, test_packages);
+                return match tested.await {
+                    Ok(tested) => {
+                        let failing = stages::test::failing_tests(&tested.junit);
+                        (tested.result, failing.unwrap_or_default())
+                    }
+                    Err(err) => (Err(err), Vec::new()),
+                };
+            }
+            Phase::DocTest => stages::doc_test::run(client, source, opts).await,
+            Phase::ModuleLint => stages::module_lint::run(client, source, None, opts).await,
+            Phase::SecurityAudit => {
+                stages::security::run(client, source, &[], Severity::Low, opts).await
+            }
+            Phase::Integration => {
+                let services = Default::default();
+                let verbosity = Default::default();
+                stages::integration::run(client, source, verbosity, &services, None, opts).await
+            }
+            Phase::FrontendBuild => stages::frontend::build(client, source, opts).await,
+            Phase::FrontendLint => stages::frontend::lint(client, source, opts).await,
+            Phase::FrontendTest => stages::frontend::test(client, source, opts).await,
+        };
+        (result, Vec::new())
+    }
+}
+
+/// Outcome of one phase: its output on success, the error (which carries the failing
+/// command's stdout/stderr) on failure, and the wall time either way.
+#[derive(Debug)]
+pub struct PhaseResult {
+    pub phase: Phase,
+    pub passed: bool,
+    pub duration: Duration,
+    pub output: String,
+    pub error: Option<String>,
+    /// `<binary> <test>` of each failing unit test; only the `Test` phase has any.
+    pub failing_tests: Vec<String>,
+}
+
+impl PhaseResult {
+    async fn capture(
+        phase: Phase,
+        client: &Query,
+        source: Directory,
+        test_packages: &[String],
+        opts: &BaseOpts,
+    ) -> Self {
+        let started = Instant::now();
+        let (result, failing_tests) = phase.run(client, source, test_packages, opts).await;
+        let duration = started.elapsed();
+        match result {
+            Ok(output) => {
+                PhaseResult { phase, passed: true, duration, output, error: None, failing_tests }
+            }
+            Err(err) => PhaseResult {
+                phase,
+                passed: false,
+                duration,
+                output: String::new(),
+                error: Some(err.to_string()),
+                failing_tests,
+            },
+        }
+    }
+}
+
+/// Run `phases` as concurrent Dagger pipelines, at most `concurrency` at a time, and
+/// return one result per finished phase in the order of `phases`. The `Test` phase
+/// covers `test_packages`, or the whole workspace when empty.
+///
+/// Every phase mounts the same `cargo-target` cache volume. Dagger mounts a shared volume
+/// as one directory across containers, and cargo takes an exclusive file lock on the target
+/// dir for the length of a build, so the compiling phases (check, lint, test, doc-test,
+/// integration) queue on that lock instead of interleaving writes; the other phases never
+/// build into it.
+///
+/// With `fail_fast` the first failure cancels the phases still in flight, which are then
+/// missing from the results; otherwise every phase runs.
+pub async fn execute(
+    client: &Query,
+    source: Directory,
+    phases: &[Phase],
+    test_packages: &[String],
+    concurrency: usize,
+    fail_fast: bool,
+    opts: &BaseOpts,
+) -> Vec<PhaseResult> {
+    let mut pending = stream::iter(phases.iter().copied().enumerate())
+        .map(|(i, phase)| {
+            let source = source.clone();
+            async move {
+                (i, PhaseResult::capture(phase, client, source, test_packages, opts).await)
+            }
+        })
+        .buffer_unordered(concurrency.max(1));
+
+    let mut results = Vec::with_capacity(phases.len());
+    while let Some((i, result)) = pending.next().await {
+        let failed = !result.passed;
+        results.push((i, result));
+        if fail_fast && failed {
+            break;
+        }
+    }
+    results.sort_by_key(|(i, _)| *i);
+    results.into_iter().map(|(_, result)| result).collect()
+}
+
+/// Human-readable summary of `results`; an error listing every failed phase if any failed.
+pub fn summary(results: &[PhaseResult], expected: usize) -> eyre::Result<String> {
+    let mut out = String::new();
+    let mut failed = Vec::new();
+    for r in results {
+        match &r.error {
+            None => out.push_str(&format!("{}\n", r.output)),
+            Some(err) => {
+                failed.push(r.phase.name());
+                out.push_str(&format!("[{}] FAILED\n{err}\n", r.phase.name()));
+            }
+        }
+    }
+    if results.len() < expected {
+        out.push_str(&format!(
+            "{} phase(s) cancelled by --fail-fast\n",
+            expected - results.len()
+        ));
+    }
+
+    out.push_str(&timing_table(results));
+
+    if !failed.is_empty() {
+        return Err(eyre::eyre!(
+            "[all] {} of {expected} phase(s) failed: {}\n{out}",
+            failed.len(),
+            failed.join(", ")
+        ));
+    }
+
+    Ok(format!("{out}\n=== Full CI Pipeline Complete ==="))
+}
+
+/// Wall time per phase, slowest first, with its share of the summed time. Phases run
+/// concurrently, so the sum exceeds the pipeline's own wall time.
+pub fn timing_table(results: &[PhaseResult]) -> String {
+    let total: f64 = results.iter().map(|r| r.duration.as_secs_f64()).sum();
+    let mut rows: Vec<&PhaseResult> = results.iter().collect();
+    rows.sort_by(|a, b| b.duration.cmp(&a.duration));
+
+    let mut out = String::from("\nPhase timings:\n");
+    for r in rows {
+        let secs = r.duration.as_secs_f64();
+        let share = if total > 0.0 { secs / total * 100.0 } else { 0.0 };
+        out.push_str(&format!(
+            "  {:<16} {secs:>8.1}s {share:>5.1}%  {}\n",
+            r.phase.name(),
+            if r.passed { "ok" } else { "FAILED" }
+        ));
+    }
+    out.push_str(&format!("  {:<16} {total:>8.1}s\n", "total"));
+    out
+}
+
+/// Lines of output kept per phase in a `CiReport`.
+const EXCERPT_LINES: usize = 40;
+
+/// Machine-readable run summary, printed by `all --json` and read back by `notify`.
+#[derive(Debug, Serialize, Deserialize)]
+pub struct CiReport {
+    pub passed: bool,
+    /// Sum of the phase durations; see `timing_table`.
+    pub duration_secs: f64,
+    pub phases: Vec<PhaseReport>,
+}
+
+/// One phase of a `CiReport`, with the tail of its output (or error) as an excerpt.
+#[derive(Debug, Serialize, Deserialize)]
+pub struct PhaseReport {
+    pub name: String,
+    pub passed: bool,
+    pub duration_secs: f64,
+    pub error: Option<String>,
+    pub log_excerpt: String,
+}
+
+impl CiReport {
+    /// Build a report from `results`; phases cancelled by fail-fast count as a failure.
+    pub fn new(results: &[PhaseResult], expected: usize) -> Self {
+        let phases: Vec<PhaseReport> = results
+            .iter()
+            .map(|r| {
+                let log = r.error.as_deref().unwrap_or(&r.output);
+                let lines: Vec<&str> = log.lines().collect();
+                PhaseReport {
+                    name: r.phase.name().to_string(),
+                    passed: r.passed,
+                    duration_secs: r.duration.as_secs_f64(),
+                    error: r.error.as_ref().map(|e| e.lines().next().unwrap_or_default().into()),
+                    log_excerpt: lines[lines.len().saturating_sub(EXCERPT_LINES)..].join("\n"),
+                }
+            })
+            .collect();
+
+        CiReport {
+            passed: phases.len() == expected && phases.iter().all(|p| p.passed),
+            duration_secs: phases.iter().map(|p| p.duration_secs).sum(),
+            phases,
+        }
+    }
+
+    pub fn to_json(&self) -> eyre::Result<String> {
+        Ok(serde_json::to_string_pretty(self)?)
+    }
+}
+
+/// JUnit XML with one `<testcase>` per phase; failed phases carry a `<failure>`
+/// element holding the captured error output.
+pub fn junit(results: &[PhaseResult]) -> String {
+    let failures = results.iter().filter(|r| !r.passed).count();
+    let total: f64 = results.iter().map(|r| r.duration.as_secs_f64()).sum();
+
+    let mut xml = String::from("<?xml version=\"1.0\" encoding=\"UTF-8\"?>\n");
+    xml.push_str(&format!(
+        "<testsuite name=\"centrix-ci\" tests=\"{}\" failures=\"{failures}\" \
+         time=\"{total:.3}\">\n",
+        results.len()
+    ));
+    for r in results {
+        xml.push_str(&format!(
+            "  <testcase classname=\"centrix-ci\" name=\"{}\" time=\"{:.3}\"",
+            r.phase.name(),
+            r.duration.as_secs_f64()
+        ));
+        match &r.error {
+            None => xml.push_str(&format!(
+                ">\n    <system-out>{}</system-out>\n  </testcase>\n",
+                escape(&r.output)
+            )),
+            Some(err) => {
+                let message = err.lines().next().unwrap_or_default();
+                xml.push_str(&format!(
+                    ">\n    <failure message=\"{}\">{}</failure>\n  </testcase>\n",
+                    escape(message),
+                    escape(err)
+                ));
+            }
+        }
+    }
+    xml.push_str("</testsuite>\n");
+    xml
+}
+
+/// Inline stylesheet of the `html` report, which loads nothing else.
+const HTML_STYLE: &str = "
+body { font: 14px/1.4 system-ui, sans-serif; margin: 2em auto; max-width: 72em; }
+.ok { color: #1a7f37; } .failed { color: #cf222e; }
+table { border-collapse: collapse; margin-bottom: 2em; }
+td, th { padding: 0.2em 1em 0.2em 0; text-align: left; }
+.bar { background: #8c959f; height: 0.8em; }
+summary { cursor: pointer; font-weight: 600; margin: 0.5em 0; }
+pre { background: #f6f8fa; overflow-x: auto; padding: 1em; white-space: pre-wrap; }
+";
+
+/// Self-contained HTML page of `results`: overall status, a timing table with bars
+/// scaled to the slowest phase, the failing unit tests, the line coverage of the
+/// `coverage` lcov tracefile when given, and each phase's complete output (test results,
+/// lint findings, ...) in a collapsible section, open for failed phases.
+pub fn html(results: &[PhaseResult], expected: usize, coverage: Option<&str>) -> String {
+    let report = CiReport::new(results, expected);
+    let status = |passed: bool| if passed { ("ok", "ok") } else { ("failed", "FAILED") };
+    let slowest = results.iter().map(|r| r.duration.as_secs_f64()).fold(0.0, f64::max);
+
+    let (class, label) = status(report.passed);
+    let mut page = format!(
+        "<!DOCTYPE html>\n<html lang=\"en\">\n<head>\n<meta charset=\"utf-8\">\n\
+         <title>centrix-ci: {label}</title>\n<style>{HTML_STYLE}</style>\n</head>\n<body>\n\
+         <h1>centrix-ci <span class=\"{class}\">{label}</span></h1>\n\
+         <p>{} of {expected} phase(s) passed, {:.1}s of phase time.</p>\n",
+        results.iter().filter(|r| r.passed).count(),
+        report.duration_secs
+    );
+    if results.len() < expected {
+        page.push_str(&format!(
+            "<p class=\"failed\">{} phase(s) cancelled by --fail-fast.</p>\n",
+            expected - results.len()
+        ));
+    }
+
+    page.push_str("<h2>Timings</h2>\n<table>\n<tr><th>Phase</th><th>Result</th><th>Time</th>\
+                   <th></th></tr>\n");
+    for r in results {
+        let (class, label) = status(r.passed);
+        let secs = r.duration.as_secs_f64();
+        let width = if slowest > 0.0 { secs / slowest * 20.0 } else { 0.0 };
+        page.push_str(&format!(
+            "<tr><td><a href=\"#{name}\">{name}</a></td><td class=\"{class}\">{label}</td>\
+             <td>{secs:.1}s</td><td><div class=\"bar\" style=\"width: {width:.1}em\"></div>\
+             </td></tr>\n",
+            name = r.phase.name()
+        ));
+    }
+    page.push_str("</table>\n");
+
+    let failing: Vec<&String> = results.iter().flat_map(|r| &r.failing_tests).collect();
+    if !failing.is_empty() {
+        page.push_str(&format!("<h2>Failing tests</h2>\n<p>{} failed:</p>\n<ul>\n", failing.len()));
+        for test in failing {
+            page.push_str(&format!("<li class=\"failed\"><code>{}</code></li>\n", escape(test)));
+        }
+        page.push_str("</ul>\n");
+    }
+
+    if let Some(lcov) = coverage {
+        let percent = stages::coverage::line_percent(lcov);
+        page.push_str(&format!(
+            "<h2>Coverage</h2>\n<p>Line coverage: {percent:.1}%</p>\n<table>\n\
+             <tr><th>File</th><th>Lines</th><th>Covered</th></tr>\n"
+        ));
+        for (file, hit, found) in stages::coverage::file_lines(lcov) {
+            let percent = if found > 0 { hit as f64 * 100.0 / found as f64 } else { 0.0 };
+            page.push_str(&format!(
+                "<tr><td>{}</td><td>{hit}/{found}</td><td>{percent:.1}%</td></tr>\n",
+                escape(&file)
+            ));
+        }
+        page.push_str("</table>\n");
+    }
+
+    page.push_str("<h2>Output</h2>\n");
+
+    for r in results {
+        let (class, label) = status(r.passed);
+        let log = r.error.as_deref().unwrap_or(&r.output);
+        page.push_str(&format!(
+            "<details id=\"{name}\"{open}>\n<summary>{name} \
+             <span class=\"{class}\">{label}</span></summary>\n<pre>{}</pre>\n</details>\n",
+            escape(log),
+            name = r.phase.name(),
+            open = if r.passed { "" } else { " open" }
+        ));
+    }
+    page.push_str("</body>\n</html>\n");
+    page
+}
+
+/// A directory with each phase's complete output as `<phase>.log`: the stage's report
+/// when it passed, the error with the failing command's stdout/stderr when it failed.
+pub fn logs(client: &Query, results: &[PhaseResult]) -> Directory {
+    results.iter().fold(client.directory(), |dir, r| {
+        let (name, secs) = (r.phase.name(), r.duration.as_secs_f64());
+        let log = match &r.error {
+            None => format!("[{name}] ok ({secs:.1}s)\n{}\n", r.output),
+            Some(err) => format!("[{name}] FAILED ({secs:.1}s)\n{err}\n"),
+        };
+        dir.with_new_file(format!("{name}.log"), log)
+    })
+}
+
+/// Run every phase of `All` and export a JUnit report of the results to `output`.
+/// The report is written even when phases fail; the summary error is returned after.
+pub async fn report(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    concurrency: usize,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let results = execute(client, source, &Phase::ALL, &[], concurrency, false, opts).await;
+
+    client
+        .directory()
+        .with_new_file("junit.xml", junit(&results))
+        .file("junit.xml")
+        .export(output)
+        .await?;
+
+    let summary = summary(&results, Phase::ALL.len())?;
+    Ok(format!("{summary}\nJUnit report exported to {output}."))
+}
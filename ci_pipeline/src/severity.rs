@@ -0,0 +1,90 @@
+use clap::ValueEnum;
+
+/// Finding severity shared by the scanning stages, ordered so thresholds compare with `>=`.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, PartialOrd, Ord, ValueEnum)]
+pub enum Severity {
+    Low,
+    Medium,
+    High,
+    Critical,
+}
+
+impl Severity {
+    /// Qualitative rating of a CVSS v3 base score.
+    pub fn of_score(score: f64) -> Self {
+        if score >= 9.0 {
+            Severity::Critical
+        } else if score >= 7.0 {
+            Severity::High
+        } else if score >= 4.0 {
+            Severity::Medium
+        } else {
+            Severity::Low
+        }
+    }
+}
+
+/// CVSS v3.x base score of a vector such as `CVSS:3.1/AV:N/AC:L/PR:N/UI:N/S:U/C:H/I:H/A:H`,
+/// or `None` when a base metric is missing or unknown.
+pub fn cvss_base_score(vector: &str) -> Option<f64> {
+    let metric = |name: &str| {
+        vector
+            .split('/')
+            .find_map(|part| part.strip_prefix(name)?.strip_prefix(':'))
+    };
+    let changed = match metric("S")? {
+        "U" => false,
+        "C" => true,
+        _ => return None,
+    };
+
+    let av = match metric("AV")? {
+        "N" => 0.85,
+        "A" => 0.62,
+        "L" => 0.55,
+        "P" => 0.2,
+        _ => return None,
+    };
+    let ac = match metric("AC")? {
+        "L" => 0.77,
+        "H" => 0.44,
+        _ => return None,
+    };
+    let pr = match (metric("PR")?, changed) {
+        ("N", _) => 0.85,
+        ("L", false) => 0.62,
+        ("L", true) => 0.68,
+        ("H", false) => 0.27,
+        ("H", true) => 0.5,
+        _ => return None,
+    };
+    let ui = match metric("UI")? {
+        "N" => 0.85,
+        "R" => 0.62,
+        _ => return None,
+    };
+    let cia = |name: &str| match metric(name)? {
+        "H" => Some(0.56),
+        "L" => Some(0.22),
+        "N" => Some(0.0),
+        _ => None,
+    };
+    let iss = 1.0 - (1.0 - cia("C")?) * (1.0 - cia("I")?) * (1.0 - cia("A")?);
+
+    let impact = if changed {
+        7.52 * (iss - 0.029) - 3.25 * (iss - 0.02f64).powi(15)
+    } else {
+        6.42 * iss
+    };
+    if impact <= 0.0 {
+        return Some(0.0);
+    }
+    let exploitability = 8.22 * av * ac * pr * ui;
+    let score = if changed {
+        1.08 * (impact + exploitability)
+    } else {
+        impact + exploitability
+    };
+    // CVSS "round up" to one decimal place.
+    Some((score.min(10.0) * 10.0 - 1e-9).ceil() / 10.0)
+}
@@ -0,0 +1,31 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::manifest;
+use crate::stages::integration::{self, Verbosity};
+
+/// Install every module under `modules/` in dependency order against a fresh
+/// PostgreSQL, then uninstall them in reverse, stopping at the first module that breaks.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    verbosity: Verbosity,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let manifests = manifest::load_all(&source).await?;
+    let order = manifest::install_order_all(&manifests)?;
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    let steps = integration::all_modules_steps(&order);
+    let db_url = containers::pg_url(client);
+    let output =
+        integration::lifecycle(client, source, pg, db_url, &steps, verbosity, opts).await?;
+
+    Ok(format!(
+        "[all-modules-lifecycle] Install order: {}\n{output}",
+        order.join(" -> ")
+    ))
+}
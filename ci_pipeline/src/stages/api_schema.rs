@@ -0,0 +1,99 @@
+use dagger_sdk::{Directory, File, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Build `erp-server` and emit its OpenAPI spec via the `openapi` subcommand.
+pub fn spec(client: &Query, source: Directory, opts: &BaseOpts) -> File {
+    containers::rust_base(client, source, opts)
+        .with_exec(vec![
+            "cargo", "build", "--release", "--package", "erp_server",
+        ])
+        .with_exec(vec![
+            "sh", "-c",
+            "./target/release/erp-server openapi > /tmp/openapi.json",
+        ])
+        .file("/tmp/openapi.json")
+}
+
+/// Export the generated `openapi.json` to `output` on the host.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    spec(client, source, opts).export(output).await?;
+
+    Ok(format!("[api-schema] OpenAPI spec exported to {output}."))
+}
+
+const OASDIFF_IMAGE: &str = "tufin/oasdiff:v1.10.25";
+const VALIDATOR_IMAGE: &str = "pythonopenapi/openapi-spec-validator:0.7.1";
+
+/// oasdiff's breaking-change report from `baseline` to `generated`, failing on errors.
+async fn breaking(client: &Query, baseline: File, generated: File) -> eyre::Result<String> {
+    Ok(client
+        .container()
+        .from(OASDIFF_IMAGE)
+        .with_file("/ci/baseline.json", baseline)
+        .with_file("/ci/openapi.json", generated)
+        .with_exec(vec![
+            "oasdiff", "breaking",
+            "/ci/baseline.json", "/ci/openapi.json",
+            "--fail-on", "ERR",
+        ])
+        .stdout()
+        .await?)
+}
+
+/// Compare the generated spec against the committed `baseline` (a path in the source tree)
+/// with oasdiff, failing on breaking changes.
+pub async fn diff(
+    client: &Query,
+    source: Directory,
+    baseline: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    let committed = source.file(baseline);
+    let output = breaking(client, committed, spec(client, source.clone(), opts)).await?;
+
+    Ok(format!("[api-schema-diff] No breaking API changes against {baseline}.\n{output}"))
+}
+
+/// The OpenAPI gate: generate the spec, validate it against the OpenAPI schema, fail on
+/// breaking changes against the committed `baseline`, and export it to `output` when
+/// given (e.g. to refresh the baseline after an intended change).
+pub async fn gate(
+    client: &Query,
+    source: Directory,
+    baseline: &str,
+    output: Option<&str>,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    let generated = spec(client, source.clone(), opts);
+    let valid = client
+        .container()
+        .from(VALIDATOR_IMAGE)
+        .with_file("/ci/openapi.json", generated.clone())
+        .with_exec(vec!["openapi-spec-validator", "/ci/openapi.json"])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[openapi] Generated spec is not valid OpenAPI:\n{e}"))?;
+    if let Some(output) = output {
+        generated.export(output).await?;
+    }
+
+    let diff = breaking(client, source.file(baseline), generated)
+        .await
+        .map_err(|e| eyre::eyre!("[openapi] Breaking changes against {baseline}:\n{e}"))?;
+
+    Ok(format!(
+        "[openapi] Spec is valid with no breaking changes against {baseline}.\n{valid}{diff}"
+    ))
+}
@@ -0,0 +1,68 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+const HURL_IMAGE: &str = "ghcr.io/orange-opensource/hurl:6.0.0";
+
+/// Host variable holding the password the suite logs in with; exposed to hurl as the
+/// `password` variable through a secret.
+const PASSWORD_ENV: &str = "CI_API_PASSWORD";
+
+/// Readiness probe, retried until the server has migrated, installed and started serving.
+const HEALTH_HURL: &str = "GET {{base_url}}/health\nHTTP 200\n";
+
+/// Start `erp-server` with `modules` installed, wait for `/health`, then run every
+/// `*.hurl` file under `suite` in the source tree (login, CRUD and permission checks)
+/// against it. The suite gets `base_url`, and `password` when `CI_API_PASSWORD` is set.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    suite: &str,
+    modules: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let suite_dir = source.directory(suite);
+    let mut files = suite_dir.glob("**/*.hurl").await?;
+    if files.is_empty() {
+        return Err(eyre::eyre!("[api-test] No .hurl files found under {suite}"));
+    }
+    files.sort();
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let modules: Vec<&str> = modules.iter().map(String::as_str).collect();
+    let pg = containers::ready_postgres(client, opts).await?;
+    let server = integration::server_service(client, source.clone(), pg, &modules, opts);
+
+    let mut hurl = client
+        .container()
+        .from(HURL_IMAGE)
+        .with_service_binding("erp", server)
+        .with_env_variable("HURL_base_url", format!("http://erp:{}", integration::SERVER_PORT))
+        .with_new_file("/ci/health.hurl", HEALTH_HURL)
+        .with_directory("/suite", suite_dir)
+        .with_workdir("/suite");
+    let password = std::env::var(PASSWORD_ENV).unwrap_or_default();
+    if !password.is_empty() {
+        let password = client.set_secret("api-password", password);
+        hurl = hurl.with_secret_variable("HURL_password", password);
+    }
+
+    // Files run one at a time, in name order, so a suite can rely on numbered prefixes.
+    let mut cmd = vec!["hurl", "--test", "--jobs", "1"];
+    cmd.extend(files.iter().map(String::as_str));
+
+    // hurl reports test progress and failures on stderr.
+    let output = hurl
+        .with_exec(vec![
+            "hurl", "--retry", "120", "--retry-interval", "1000", "--no-output",
+            "/ci/health.hurl",
+        ])
+        .with_exec(cmd)
+        .stderr()
+        .await?;
+
+    Ok(format!("[api-test] {} suite file(s) passed.\n{output}", files.len()))
+}
@@ -0,0 +1,72 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::security::AuditReport;
+
+/// Run `cargo audit fix` and return the updated source tree (without `target/`),
+/// reporting which advisories the upgrade resolved and which remain (typically
+/// those needing a semver-incompatible bump or without a patched release).
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    // Installed before the source is mounted, with the advisory database in the cache
+    // volume `security::audit_tools` uses, so only new advisories are fetched.
+    let toolchain = containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(
+            &["cargo", "install", "cargo-audit", "--locked", "--features", "fix"],
+            opts,
+        ))
+        .with_mounted_cache(
+            "/usr/local/cargo/advisory-db",
+            client.cache_volume("cargo-advisory-db"),
+        );
+    let fixed = containers::with_source(toolchain, source)
+        .with_exec(vec!["sh", "-c", "cargo audit --json > /tmp/before.json || true"])
+        .with_exec(vec!["sh", "-c", "cargo audit fix > /tmp/fix.log 2>&1 || true"])
+        .with_exec(vec!["sh", "-c", "cargo audit --json > /tmp/after.json || true"]);
+
+    let before: AuditReport =
+        serde_json::from_str(&fixed.file("/tmp/before.json").contents().await?)?;
+    let after: AuditReport =
+        serde_json::from_str(&fixed.file("/tmp/after.json").contents().await?)?;
+    let fix_log = fixed.file("/tmp/fix.log").contents().await?;
+
+    fixed
+        .directory("/app")
+        .without_directory("target")
+        .export(output)
+        .await?;
+
+    let resolved: Vec<String> = before
+        .vulnerabilities
+        .list
+        .iter()
+        .filter(|v| {
+            !after
+                .vulnerabilities
+                .list
+                .iter()
+                .any(|a| a.advisory.id == v.advisory.id && a.package.name == v.package.name)
+        })
+        .map(|v| v.describe())
+        .collect();
+    let remaining: Vec<String> = after.vulnerabilities.list.iter().map(|v| v.describe()).collect();
+
+    let mut report = format!(
+        "[audit-fix] Updated source exported to {output}. {} resolved, {} remaining.\n",
+        resolved.len(),
+        remaining.len()
+    );
+    for line in &resolved {
+        report.push_str(&format!("  resolved: {line}\n"));
+    }
+    for line in &remaining {
+        report.push_str(&format!("  remaining: {line}\n"));
+    }
+    report.push_str(&fix_log);
+
+    Ok(report)
+}
@@ -0,0 +1,115 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Container, Directory, Query, Service};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration::{self, Verbosity};
+use crate::stages::module_lifecycle::valid_identifier;
+use crate::stages::upgrade::{count_rows, parse_counts};
+
+/// Schema of `$DATABASE_URL` as `pg_dump` sees it, less comments, blank lines and the
+/// per-dump `\restrict` keys, so two dumps of the same schema compare equal.
+const SCHEMA_SCRIPT: &str = r#"
+set -euo pipefail
+pg_dump --schema-only --no-owner --no-privileges "$DATABASE_URL" \
+    | grep -v -e '^--' -e '^\\restrict' -e '^\\unrestrict' -e '^$'
+"#;
+
+/// The documented backup procedure: a custom-format dump of `$DATABASE_URL`.
+const BACKUP: &[&str] =
+    &["sh", "-c", r#"pg_dump -Fc --no-owner -f /ci/erp.dump "$DATABASE_URL""#];
+
+/// The documented restore procedure, into the empty `$DATABASE_URL`.
+const RESTORE: &[&str] = &[
+    "sh", "-c",
+    r#"pg_restore --no-owner --no-privileges --exit-on-error -d "$DATABASE_URL" /ci/erp.dump"#,
+];
+
+/// `pg_dump`/`psql` from the server's own image, with `db` bound as host `db`.
+fn pg_client(client: &Query, db: Service, nonce: &str, opts: &BaseOpts) -> Container {
+    client
+        .container()
+        .from(containers::pg_image(opts))
+        .with_service_binding("db", db)
+        .with_secret_variable("DATABASE_URL", containers::pg_url(client))
+        .with_env_variable("CI_BACKUP_RUN", nonce)
+}
+
+/// Run the integration lifecycle, back the database up with `pg_dump`, restore the dump
+/// into a second fresh PostgreSQL with `pg_restore`, and fail unless the restored schema
+/// and the row counts of `tables` match the original.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    tables: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    if let Some(table) = tables.iter().find(|t| !valid_identifier(t)) {
+        return Err(eyre::eyre!("invalid table name '{table}'"));
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    // Both databases outlive the containers that fill and read them.
+    let original = containers::ready_isolated_postgres(client, opts, "original").await?;
+    let restored = containers::ready_isolated_postgres(client, opts, "restored").await?;
+
+    let steps = integration::default_steps();
+    let db_url = containers::pg_url(client);
+    let quiet = Verbosity::Quiet;
+    integration::lifecycle(client, source, original.clone(), db_url, &steps, quiet, opts)
+        .await
+        .map_err(|e| eyre::eyre!("[backup-restore] Lifecycle failed before the backup:\n{e}"))?;
+
+    // Every step reads or writes a live database; never let Dagger answer from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos().to_string();
+    let backed_up = pg_client(client, original.clone(), &nonce, opts).with_exec(BACKUP.to_vec());
+    let schema = backed_up.with_exec(vec!["bash", "-c", SCHEMA_SCRIPT]).stdout().await?;
+    let before = parse_counts(&count_rows(&backed_up, tables).stdout().await?);
+    let dump = backed_up.file("/ci/erp.dump");
+    let size = dump.size().await?;
+
+    let restore = pg_client(client, restored.clone(), &nonce, opts)
+        .with_file("/ci/erp.dump", dump)
+        .with_exec(RESTORE.to_vec());
+    let restored_schema = restore
+        .with_exec(vec!["bash", "-c", SCHEMA_SCRIPT])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[backup-restore] Restore failed:\n{e}"))?;
+    let after = parse_counts(&count_rows(&restore, tables).stdout().await?);
+
+    original.stop().await?;
+    restored.stop().await?;
+
+    if restored_schema != schema {
+        let diff = client
+            .container()
+            .from(containers::pg_image(opts))
+            .with_new_file("/ci/original.sql", schema)
+            .with_new_file("/ci/restored.sql", restored_schema)
+            .with_exec(vec!["sh", "-c", "diff -u /ci/original.sql /ci/restored.sql || true"])
+            .stdout()
+            .await?;
+        return Err(eyre::eyre!("[backup-restore] Restored schema differs:\n{diff}"));
+    }
+
+    let mut report = format!("  dump: {size} bytes\n");
+    let mut mismatched = Vec::new();
+    for table in tables {
+        let (b, a) = (before.get(table).copied(), after.get(table).copied());
+        report.push_str(&format!("  {table}: {b:?} -> {a:?}\n"));
+        if a != b {
+            mismatched.push(table.as_str());
+        }
+    }
+    if !mismatched.is_empty() {
+        return Err(eyre::eyre!(
+            "[backup-restore] Row counts differ after restore in: {}\n{report}",
+            mismatched.join(", ")
+        ));
+    }
+
+    Ok(format!("[backup-restore] Backup restored with an identical schema.\n{report}"))
+}
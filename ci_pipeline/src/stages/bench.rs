@@ -0,0 +1,92 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+use serde::Deserialize;
+
+use crate::containers::{self, BaseOpts};
+
+/// Criterion output directory; outside the cargo-target cache so it can be exported.
+const CRITERION_HOME: &str = "/tmp/criterion";
+
+/// The part of criterion's `estimates.json` the comparison needs.
+#[derive(Deserialize)]
+struct Estimates {
+    mean: Estimate,
+}
+
+#[derive(Deserialize)]
+struct Estimate {
+    /// Nanoseconds per iteration.
+    point_estimate: f64,
+}
+
+/// Mean time of the benchmark whose latest run is at `path` in `dir`, if it has one.
+async fn mean_ns(dir: &Directory, path: &str) -> eyre::Result<f64> {
+    let json = dir.file(path).contents().await?;
+    let estimates: Estimates =
+        serde_json::from_str(&json).map_err(|e| eyre::eyre!("{path}: {e}"))?;
+    Ok(estimates.mean.point_estimate)
+}
+
+/// Run the workspace benchmarks with `cargo bench` and export criterion's directory to
+/// `output`. With `baseline` (a criterion directory exported by an earlier run), fail
+/// when any benchmark's mean time grew by more than `max_regression_percent`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    baseline: Option<Directory>,
+    max_regression_percent: f64,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    // Timings differ from run to run; a cached result would hide a regression.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let criterion = containers::rust_base(client, source, opts)
+        .with_env_variable("CRITERION_HOME", CRITERION_HOME)
+        .with_env_variable("CI_BENCH_RUN", nonce.to_string())
+        .with_exec(vec!["cargo", "bench", "--workspace"])
+        .directory(CRITERION_HOME);
+    criterion.export(output).await?;
+
+    let Some(baseline) = baseline else {
+        return Ok(format!("[bench] Benchmarks complete (criterion reports in {output})."));
+    };
+
+    let mut report = String::new();
+    let mut regressed = Vec::new();
+    let mut compared = 0;
+    for path in criterion.glob("**/new/estimates.json").await? {
+        let name = path.trim_end_matches("/new/estimates.json");
+        let Ok(before) = mean_ns(&baseline, &path).await else {
+            report.push_str(&format!("  {name}: new benchmark, no baseline\n"));
+            continue;
+        };
+        let after = mean_ns(&criterion, &path).await?;
+        let change = (after - before) / before * 100.0;
+        compared += 1;
+        report.push_str(&format!(
+            "  {name}: {:.1}us -> {:.1}us ({change:+.1}%)\n",
+            before / 1000.0,
+            after / 1000.0
+        ));
+        if change > max_regression_percent {
+            regressed.push(name.to_string());
+        }
+    }
+
+    if !regressed.is_empty() {
+        return Err(eyre::eyre!(
+            "[bench] {} regression(s) over {max_regression_percent:.1}%: {}\n{report}",
+            regressed.len(),
+            regressed.join(", ")
+        ));
+    }
+
+    Ok(format!(
+        "[bench] {compared} benchmark(s) within {max_regression_percent:.1}% of the baseline \
+         (criterion reports in {output}).\n{report}"
+    ))
+}
@@ -0,0 +1,29 @@
+use dagger_sdk::{Directory, File, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Build `erp-server` in release mode and return the binary. It is copied out of the
+/// `cargo-target` cache mount first, since files inside a cache mount can't be exported.
+pub fn binary(client: &Query, source: Directory, opts: &BaseOpts) -> File {
+    containers::rust_base(client, source, opts)
+        .with_exec(vec![
+            "cargo", "build", "--release", "--package", "erp_server",
+        ])
+        .with_exec(vec!["cp", "target/release/erp-server", "/tmp/erp-server"])
+        .file("/tmp/erp-server")
+}
+
+/// Export the release `erp-server` binary to `output` on the host.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    binary(client, source, opts).export(output).await?;
+
+    Ok(format!("[build] Release binary exported to {output}."))
+}
@@ -0,0 +1,93 @@
+use dagger_sdk::{Container, Directory, File, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// A supported cross target: the Debian packages providing its C toolchain and
+/// libraries, and the environment pointing cargo, cc and pkg-config at them.
+struct CrossTarget {
+    triple: &'static str,
+    setup: &'static [&'static str],
+    packages: &'static [&'static str],
+    env: &'static [(&'static str, &'static str)],
+}
+
+const TARGETS: &[CrossTarget] = &[
+    // Static musl build. libpq is not available for musl from Debian, so the workspace
+    // must link it statically via the `bundled` feature of `pq-sys`.
+    CrossTarget {
+        triple: "x86_64-unknown-linux-musl",
+        setup: &[],
+        packages: &["musl-tools"],
+        env: &[("CC_x86_64_unknown_linux_musl", "musl-gcc")],
+    },
+    CrossTarget {
+        triple: "aarch64-unknown-linux-gnu",
+        setup: &["dpkg --add-architecture arm64", "apt-get update"],
+        packages: &["gcc-aarch64-linux-gnu", "libc6-dev-arm64-cross", "libpq-dev:arm64"],
+        env: &[
+            ("CARGO_TARGET_AARCH64_UNKNOWN_LINUX_GNU_LINKER", "aarch64-linux-gnu-gcc"),
+            ("CC_aarch64_unknown_linux_gnu", "aarch64-linux-gnu-gcc"),
+            ("PKG_CONFIG_ALLOW_CROSS", "1"),
+            ("PKG_CONFIG_PATH", "/usr/lib/aarch64-linux-gnu/pkgconfig"),
+        ],
+    },
+];
+
+/// Every supported cross target triple.
+pub fn triples() -> impl Iterator<Item = &'static str> {
+    TARGETS.iter().map(|t| t.triple)
+}
+
+/// Rust toolchain with the standard library and cross linker for `target` installed,
+/// before the source is mounted so the setup stays cached.
+fn cross_toolchain(client: &Query, target: &CrossTarget, opts: &BaseOpts) -> Container {
+    let mut container = containers::rust_toolchain(client, opts);
+    for cmd in target.setup {
+        container = container.with_exec(containers::retried(&["sh", "-c", cmd], opts));
+    }
+    let install = [&["apt-get", "install", "-y"][..], target.packages].concat();
+    container = container
+        .with_exec(containers::retried(&install, opts))
+        .with_exec(containers::retried(&["rustup", "target", "add", target.triple], opts));
+    for (name, value) in target.env {
+        container = container.with_env_variable(*name, *value);
+    }
+    container
+}
+
+/// Cross-compile `erp-server` for `triple` and return the binary.
+pub fn binary(
+    client: &Query,
+    source: Directory,
+    triple: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<File> {
+    let target = TARGETS.iter().find(|t| t.triple == triple).ok_or_else(|| {
+        let supported: Vec<&str> = TARGETS.iter().map(|t| t.triple).collect();
+        eyre::eyre!("unsupported target '{triple}', expected one of: {}", supported.join(", "))
+    })?;
+
+    let built = format!("target/{triple}/release/erp-server");
+    Ok(containers::with_source(cross_toolchain(client, target, opts), source)
+        .with_exec(vec![
+            "cargo", "build", "--release", "--package", "erp_server", "--target", triple,
+        ])
+        .with_exec(vec!["cp", built.as_str(), "/tmp/erp-server"])
+        .file("/tmp/erp-server"))
+}
+
+/// Export the `erp-server` binary for `triple` to `output` on the host.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    triple: &str,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let binary = binary(client, source.clone(), triple, opts)?;
+    containers::ensure_free_disk(&containers::rust_base(client, source, opts), opts).await?;
+
+    binary.export(output).await?;
+
+    Ok(format!("[build-cross] {triple} binary exported to {output}."))
+}
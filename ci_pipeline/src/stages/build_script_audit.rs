@@ -0,0 +1,86 @@
+use dagger_sdk::Directory;
+
+/// Patterns flagged as `network`.
+const NETWORK: &[&str] = &[
+    "TcpStream", "UdpSocket", "ToSocketAddrs", "reqwest", "ureq", "hyper::", "curl",
+    "http://", "https://",
+];
+/// Patterns flagged as `write-outside-out-dir` unless the line mentions `OUT_DIR`.
+const FS_WRITE: &[&str] = &[
+    "fs::write", "File::create", "OpenOptions", "create_dir", "fs::copy", "fs::rename",
+    "remove_file", "remove_dir",
+];
+/// Binaries a build script may reasonably invoke.
+const ALLOWED_COMMANDS: &[&str] = &[
+    "\"rustc\"", "\"pkg-config\"", "\"cc\"", "\"c++\"", "\"ar\"", "\"git\"", "RUSTC", "\"CC\"",
+];
+
+struct Finding {
+    path: String,
+    line: usize,
+    rule: &'static str,
+    snippet: String,
+}
+
+/// Scan every `build.rs` for network access, writes outside `OUT_DIR`, and
+/// `std::process::Command` calls to arbitrary binaries. Heuristic: findings are
+/// for review, and only fail the run when `deny` is set.
+pub async fn run(source: Directory, deny: bool) -> eyre::Result<String> {
+    let paths = source.glob("**/build.rs").await?;
+
+    let mut findings = Vec::new();
+    for path in &paths {
+        let contents = source.file(path.as_str()).contents().await?;
+        findings.extend(audit(path, &contents));
+    }
+
+    let mut report = format!(
+        "[build-script-audit] Scanned {} build script(s), {} finding(s).\n",
+        paths.len(),
+        findings.len()
+    );
+    for f in &findings {
+        report.push_str(&format!("  {}:{} [{}] {}\n", f.path, f.line, f.rule, f.snippet));
+    }
+
+    if deny && !findings.is_empty() {
+        return Err(eyre::eyre!(report));
+    }
+    Ok(report)
+}
+
+fn audit(path: &str, contents: &str) -> Vec<Finding> {
+    let mut findings = Vec::new();
+
+    for (idx, raw) in contents.lines().enumerate() {
+        let line = raw.trim();
+        if line.starts_with("//") {
+            continue;
+        }
+
+        let rule = if NETWORK.iter().any(|p| line.contains(p)) {
+            Some("network")
+        } else if FS_WRITE.iter().any(|p| line.contains(p))
+            && !line.contains("OUT_DIR")
+            && !line.contains("out_dir")
+        {
+            Some("write-outside-out-dir")
+        } else if let Some(args) = line.split("Command::new(").nth(1) {
+            (!ALLOWED_COMMANDS.iter().any(|c| args.contains(c)))
+                .then_some("process")
+        } else {
+            None
+        };
+
+        if let Some(rule) = rule {
+            findings.push(Finding {
+                path: path.to_string(),
+                line: idx + 1,
+                rule,
+                snippet: line.to_string(),
+            });
+        }
+    }
+
+    findings
+}
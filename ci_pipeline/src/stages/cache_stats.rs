@@ -0,0 +1,34 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// sccache counters only live as long as its server, which dies with the exec, so the
+/// build and the stats query share one.
+const STATS_SCRIPT: &str = r#"
+set -euo pipefail
+sccache --zero-stats > /dev/null
+cargo build --workspace 2>&1 | tail -3
+sccache --show-stats
+"#;
+
+/// Build the workspace through sccache (enabled even without `--sccache`) and print
+/// the cache hit/miss statistics of that build, to tell whether a remote cache is
+/// actually being hit. The build starts from an empty target dir outside the
+/// `cargo-target` cache, so every crate goes through sccache.
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let opts = BaseOpts { sccache: true, ..opts.clone() };
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), &opts), &opts)
+        .await?;
+
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let output = containers::rust_base(client, source, &opts)
+        .with_env_variable("CARGO_TARGET_DIR", "/tmp/cache-stats-target")
+        .with_env_variable("CI_CACHE_STATS_RUN", nonce.to_string())
+        .with_exec(vec!["bash", "-c", STATS_SCRIPT])
+        .stdout()
+        .await?;
+
+    Ok(format!("[cache-stats] {output}"))
+}
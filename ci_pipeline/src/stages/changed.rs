@@ -0,0 +1,180 @@
+use std::collections::BTreeSet;
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::BaseOpts;
+use crate::git;
+use crate::metadata::{self, Workspace};
+use crate::pipeline::{self, Phase};
+
+/// Frontend sources; changes here only affect the frontend phases.
+const FRONTEND_DIR: &str = "erp_web/static/";
+
+/// Files that never affect a build or test result.
+fn is_doc(path: &str) -> bool {
+    path.ends_with(".md") || path.starts_with("docs/") || path.starts_with("LICENSE")
+}
+
+/// Files at the workspace root (Cargo.toml, Cargo.lock, toolchain files) or under
+/// `.cargo/` change how every crate builds.
+fn is_workspace_wide(path: &str) -> bool {
+    !path.contains('/') || path.starts_with(".cargo/")
+}
+
+/// Paths changed on `HEAD` since it forked from `base_ref`, read from the `.git`
+/// directory of `repo` (the host checkout, including `.git`).
+async fn changed_files(
+    client: &Query,
+    repo: Directory,
+    base_ref: &str,
+) -> eyre::Result<Vec<String>> {
+    let output = git::container(client, repo)
+        .with_exec(vec!["git", "diff", "--name-only", &format!("{base_ref}...HEAD")])
+        .stdout()
+        .await?;
+
+    Ok(output.lines().map(str::to_string).filter(|l| !l.is_empty()).collect())
+}
+
+/// The phases `files` call for, and the crates whose unit tests need to run (empty
+/// meaning the whole workspace), each with the reason it was picked.
+struct Plan {
+    phases: Vec<Phase>,
+    test_packages: Vec<String>,
+    reasons: Vec<String>,
+}
+
+/// Decide what to run for `files`: frontend phases only for frontend changes, nothing
+/// for documentation, module lint for `modules/`, the security audit for manifest and
+/// lockfile changes, and the Rust phases, integration included, for anything else.
+/// Unit tests are narrowed to the crates containing a changed file plus their
+/// workspace dependents.
+fn plan(files: &[String], workspace: &Workspace) -> Plan {
+    let code: Vec<&str> = files.iter().map(String::as_str).filter(|f| !is_doc(f)).collect();
+    let frontend = code.iter().any(|f| f.starts_with(FRONTEND_DIR));
+    let rust: Vec<&str> = code.iter().copied().filter(|f| !f.starts_with(FRONTEND_DIR)).collect();
+    let modules = rust.iter().any(|f| f.starts_with("modules/"));
+    let manifests = rust
+        .iter()
+        .any(|f| f.ends_with("Cargo.toml") || f.ends_with("Cargo.lock") || *f == "deny.toml");
+
+    let mut reasons = vec![format!(
+        "{} changed file(s), {} documentation only",
+        files.len(),
+        files.len() - code.len()
+    )];
+    let mut phases = Vec::new();
+    let mut test_packages = Vec::new();
+    if !rust.is_empty() {
+        phases.extend([Phase::Check, Phase::Fmt, Phase::Lint]);
+        if rust.iter().any(|f| is_workspace_wide(f)) {
+            reasons.push("workspace-wide file changed: testing every crate".into());
+            phases.push(Phase::Test);
+        } else {
+            test_packages = affected_packages(&rust, workspace);
+            if test_packages.is_empty() {
+                reasons.push("no crate changed: unit tests skipped".into());
+            } else {
+                reasons.push(format!("crates to test: {}", test_packages.join(", ")));
+                phases.push(Phase::Test);
+            }
+        }
+        phases.push(Phase::DocTest);
+    }
+    if modules {
+        phases.push(Phase::ModuleLint);
+    }
+    if manifests {
+        phases.push(Phase::SecurityAudit);
+    }
+    if !rust.is_empty() {
+        phases.push(Phase::Integration);
+    }
+    if frontend {
+        phases.extend([Phase::FrontendBuild, Phase::FrontendLint, Phase::FrontendTest]);
+    }
+
+    let skipped: Vec<&str> = Phase::ALL
+        .iter()
+        .filter(|p| !phases.contains(p))
+        .map(|p| p.name())
+        .collect();
+    if !skipped.is_empty() {
+        reasons.push(format!("skipped: {}", skipped.join(", ")));
+    }
+    Plan { phases, test_packages, reasons }
+}
+
+/// Workspace members containing one of `files`, plus every member depending on one of
+/// those, transitively.
+fn affected_packages(files: &[&str], workspace: &Workspace) -> Vec<String> {
+    let dirs: Vec<(String, &str)> = workspace
+        .members()
+        .map(|p| {
+            let manifest = p.manifest_path.trim_start_matches("/app/");
+            (manifest.trim_end_matches("Cargo.toml").to_string(), p.name.as_str())
+        })
+        .collect();
+
+    let mut affected = BTreeSet::new();
+    for file in files {
+        // The deepest crate directory containing the file owns it.
+        let owner = dirs
+            .iter()
+            .filter(|(dir, _)| file.starts_with(dir.as_str()))
+            .max_by_key(|(dir, _)| dir.len());
+        if let Some((_, name)) = owner {
+            affected.insert(name.to_string());
+        }
+    }
+
+    loop {
+        let dependents: Vec<String> = workspace
+            .members()
+            .filter(|p| !affected.contains(&p.name))
+            .filter(|p| p.dependencies.iter().any(|d| affected.contains(&d.name)))
+            .map(|p| p.name.clone())
+            .collect();
+        if dependents.is_empty() {
+            break;
+        }
+        affected.extend(dependents);
+    }
+    affected.into_iter().collect()
+}
+
+/// Run only the phases the changes since `base_ref` call for; see `plan`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    repo: Directory,
+    base_ref: &str,
+    concurrency: usize,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let files = changed_files(client, repo, base_ref).await?;
+    if files.is_empty() {
+        return Ok(format!("[changed] No changes since {base_ref}; nothing to run."));
+    }
+
+    let workspace = metadata::load(client, source.clone(), opts).await?;
+    let plan = plan(&files, &workspace);
+    let header = format!("[changed] Since {base_ref}:\n  {}\n", plan.reasons.join("\n  "));
+    if plan.phases.is_empty() {
+        return Ok(format!("{header}Only documentation changed; nothing to run."));
+    }
+
+    let results = pipeline::execute(
+        client,
+        source,
+        &plan.phases,
+        &plan.test_packages,
+        concurrency,
+        false,
+        opts,
+    )
+    .await;
+    let summary = pipeline::summary(&results, plan.phases.len())
+        .map_err(|e| eyre::eyre!("{header}{e}"))?;
+    Ok(format!("{header}{summary}"))
+}
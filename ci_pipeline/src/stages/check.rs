@@ -1,10 +1,13 @@
 use dagger_sdk::{Directory, Query};
 
-use crate::containers;
+use crate::containers::{self, BaseOpts};
 
 /// Run `cargo check --workspace` to verify compilation.
-pub async fn run(client: &Query, source: Directory) -> eyre::Result<String> {
-    let output = containers::rust_base(client, source)
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let base = containers::rust_base(client, source, opts);
+    containers::ensure_free_disk(&base, opts).await?;
+
+    let output = base
         .with_exec(vec!["cargo", "check", "--workspace"])
         .stdout()
         .await?;
@@ -0,0 +1,29 @@
+use serde::Deserialize;
+
+/// One line of `cargo clippy --message-format=json`; only compiler messages are kept.
+#[derive(Deserialize)]
+pub struct CargoMessage {
+    pub reason: String,
+    pub message: Option<Diagnostic>,
+}
+
+#[derive(Deserialize)]
+pub struct Diagnostic {
+    pub message: String,
+    pub level: String,
+    pub code: Option<DiagnosticCode>,
+    pub spans: Vec<Span>,
+}
+
+#[derive(Deserialize)]
+pub struct DiagnosticCode {
+    pub code: String,
+}
+
+#[derive(Deserialize)]
+pub struct Span {
+    pub file_name: String,
+    pub line_start: usize,
+    pub column_start: usize,
+    pub is_primary: bool,
+}
@@ -0,0 +1,60 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration::{self, Verbosity};
+
+/// Lowercase log fragments CockroachDB emits for Postgres features it does not support:
+/// its `unimplemented` errors, SQLSTATE `0A000` (feature_not_supported), and
+/// "... is not supported" messages. Other errors are module failures, not incompatibilities.
+const INCOMPATIBILITY_MARKERS: &[&str] = &["unimplemented", "0a000", "is not supported"];
+
+/// Run the module lifecycle against CockroachDB instead of PostgreSQL
+/// and report any incompatibilities (unsupported SQL, differing error behavior).
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    let crdb = containers::cockroach(client);
+    containers::wait_for_service(&crdb, "cockroachdb", containers::SERVICE_READY_TIMEOUT).await?;
+    let db_url = client.set_secret(
+        "cockroach-url",
+        "postgresql://root@db:26257/defaultdb?sslmode=disable",
+    );
+
+    let steps = integration::default_steps();
+    let lifecycle =
+        integration::lifecycle(client, source, crdb, db_url, &steps, Verbosity::Normal, opts);
+    let (output, completed) = match lifecycle.await {
+        Ok(output) => (output, true),
+        Err(e) => (e.to_string(), false),
+    };
+
+    let findings = incompatibilities(&output);
+    if findings.is_empty() && completed {
+        return Ok(format!(
+            "[cockroach] Lifecycle passed, no incompatibilities found.\n{output}"
+        ));
+    }
+
+    let mut report = String::from("[cockroach] CockroachDB incompatibilities:\n");
+    for line in &findings {
+        report.push_str(&format!("  - {line}\n"));
+    }
+    if !completed {
+        report.push_str("Lifecycle did not complete.\n");
+    }
+    report.push_str(&output);
+
+    Err(eyre::eyre!(report))
+}
+
+/// Unique output lines matching any incompatibility marker, in first-seen order.
+fn incompatibilities(output: &str) -> Vec<&str> {
+    let mut found: Vec<&str> = Vec::new();
+    for line in output.lines().map(str::trim) {
+        let lower = line.to_lowercase();
+        if INCOMPATIBILITY_MARKERS.iter().any(|m| lower.contains(m)) && !found.contains(&line) {
+            found.push(line);
+        }
+    }
+    found
+}
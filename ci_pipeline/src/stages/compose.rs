@@ -0,0 +1,76 @@
+use dagger_sdk::{File, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+/// Render a `docker-compose.yml` mirroring the CI services: the `db` Postgres service from
+/// `containers::postgres` and the server from `integration::server_service`, built from the
+/// repository root with the same base image and packages. Images follow `--rust-base-digest`
+/// and `--pg-digest`, so a pinned CI run and `docker compose up` use identical images.
+pub fn file(client: &Query, opts: &BaseOpts) -> File {
+    let db_env: String = containers::PG_ENV
+        .iter()
+        .map(|(name, value)| format!("      {name}: {value}\n"))
+        .collect();
+    let db_password = containers::DEFAULT_PG_PASSWORD;
+    let (db_user, db_name) = (containers::PG_ENV[1].1, containers::PG_ENV[0].1);
+
+    let command = integration::server_script("erp-server", &[]);
+    let command: String = command.lines().map(|line| format!("        {line}\n")).collect();
+
+    let yaml = format!(
+        r#"# Generated by `ci_pipeline compose`; regenerate instead of editing by hand.
+services:
+  db:
+    image: {pg_image}
+    environment:
+{db_env}      POSTGRES_PASSWORD: {db_password}
+    ports:
+      - "{pg_port}:{pg_port}"
+    healthcheck:
+      test: ["CMD", "pg_isready", "-U", "{db_user}", "-d", "{db_name}"]
+      interval: 2s
+      retries: 15
+
+  server:
+    build:
+      context: .
+      dockerfile_inline: |
+        FROM {rust_image}
+        RUN apt-get update && apt-get install -y {packages}
+        WORKDIR /app
+        COPY . .
+        RUN cargo build --release --package erp_server \
+            && cp target/release/erp-server /usr/local/bin/erp-server
+    environment:
+      DATABASE_URL: postgres://{db_user}:{db_password}@db:{pg_port}/{db_name}
+      RUST_LOG: info
+    ports:
+      - "{server_port}:{server_port}"
+    depends_on:
+      db:
+        condition: service_healthy
+    command:
+      - bash
+      - -c
+      - |
+{command}"#,
+        pg_image = containers::pg_image(opts),
+        pg_port = containers::PG_PORT,
+        rust_image = containers::rust_image(opts),
+        packages = containers::BUILD_PACKAGES.join(" "),
+        server_port = integration::SERVER_PORT,
+    );
+
+    client
+        .directory()
+        .with_new_file("docker-compose.yml", yaml)
+        .file("docker-compose.yml")
+}
+
+/// Export the generated compose file to `output` on the host.
+pub async fn run(client: &Query, output: &str, opts: &BaseOpts) -> eyre::Result<String> {
+    file(client, opts).export(output).await?;
+
+    Ok(format!("[compose] docker-compose.yml exported to {output}."))
+}
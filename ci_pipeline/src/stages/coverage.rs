@@ -0,0 +1,86 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+const COVERAGE_SCRIPT: &str = r#"
+set -euo pipefail
+mkdir -p /tmp/coverage
+cargo llvm-cov --workspace --lib --lcov --output-path /tmp/coverage/lcov.info
+cargo llvm-cov report --cobertura --output-path /tmp/coverage/cobertura.xml
+cargo llvm-cov report --html --output-dir /tmp/coverage
+"#;
+
+/// Run the workspace lib tests under `cargo-llvm-cov` and return a directory with
+/// `lcov.info`, `cobertura.xml` and the browsable `html/` report. `llvm-tools` and
+/// `cargo-llvm-cov` are installed before the source is mounted, so they are only rebuilt
+/// when the toolchain changes.
+pub fn reports(client: &Query, source: Directory, opts: &BaseOpts) -> Directory {
+    let toolchain = containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(&["rustup", "component", "add", "llvm-tools-preview"], opts))
+        .with_exec(containers::retried(&["cargo", "install", "cargo-llvm-cov", "--locked"], opts));
+
+    containers::with_source(toolchain, source)
+        .with_exec(vec!["bash", "-c", COVERAGE_SCRIPT])
+        .directory("/tmp/coverage")
+}
+
+/// Total line coverage in percent from an lcov tracefile (`LH` hit over `LF` found).
+pub fn line_percent(lcov: &str) -> f64 {
+    let (mut hit, mut found) = (0u64, 0u64);
+    for line in lcov.lines() {
+        if let Some(n) = line.strip_prefix("LH:") {
+            hit += n.trim().parse::<u64>().unwrap_or(0);
+        } else if let Some(n) = line.strip_prefix("LF:") {
+            found += n.trim().parse::<u64>().unwrap_or(0);
+        }
+    }
+    if found == 0 {
+        return 0.0;
+    }
+    hit as f64 * 100.0 / found as f64
+}
+
+/// `(file, lines hit, lines found)` of each `SF:` record of an lcov tracefile, in
+/// tracefile order.
+pub fn file_lines(lcov: &str) -> Vec<(String, u64, u64)> {
+    let mut files = Vec::new();
+    for line in lcov.lines() {
+        if let Some(file) = line.strip_prefix("SF:") {
+            files.push((file.trim().to_string(), 0, 0));
+        } else if let Some((_, hit, found)) = files.last_mut() {
+            if let Some(n) = line.strip_prefix("LH:") {
+                *hit += n.trim().parse::<u64>().unwrap_or(0);
+            } else if let Some(n) = line.strip_prefix("LF:") {
+                *found += n.trim().parse::<u64>().unwrap_or(0);
+            }
+        }
+    }
+    files
+}
+
+/// Export the coverage reports to `output` and print the total line coverage.
+/// With a non-zero `min_percent` the stage fails below that threshold; the
+/// reports are exported either way so the gaps can be inspected.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    min_percent: f64,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let reports = reports(client, source, opts);
+    let percent = line_percent(&reports.file("lcov.info").contents().await?);
+    reports.export(output).await?;
+
+    let summary = format!("Line coverage: {percent:.1}%");
+    if min_percent > 0.0 && percent < min_percent {
+        return Err(eyre::eyre!(
+            "[coverage] {summary}, below the {min_percent:.1}% minimum (reports in {output})"
+        ));
+    }
+
+    Ok(format!("[coverage] {summary} (lcov, cobertura and HTML exported to {output})"))
+}
@@ -0,0 +1,21 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Open an interactive shell in `rust_base` with caches mounted, PostgreSQL bound
+/// as `db` and `DATABASE_URL` set, to reproduce CI-only failures in the exact
+/// container state. Needs a terminal attached through the dagger CLI:
+///
+/// `dagger run cargo run -p centrix-ci-pipeline -- debug-shell --source=..`
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let pg = containers::ready_postgres(client, opts).await?;
+
+    containers::rust_base(client, source, opts)
+        .with_service_binding("db", pg)
+        .with_secret_variable("DATABASE_URL", containers::pg_url(client))
+        .terminal()
+        .sync()
+        .await?;
+
+    Ok("[debug-shell] Session closed.".to_string())
+}
@@ -0,0 +1,34 @@
+use dagger_sdk::{Directory, File, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::security;
+
+/// Run `cargo deny check` for licenses, bans (duplicate and banned crates) and sources.
+/// The policy is `config` when given, else the workspace's `deny.toml`, else cargo-deny's
+/// built-in defaults. Advisories are left to `security-audit`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    config: Option<File>,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let has_deny_toml = !source.glob("deny.toml").await?.is_empty();
+
+    let mut container = containers::with_source(security::audit_tools(client, opts), source);
+    let mut args = vec!["cargo", "deny"];
+    let policy = match config {
+        Some(file) => {
+            let policy = format!("--config {}", file.name().await?);
+            container = container.with_file("/ci/deny.toml", file);
+            args.extend(["--config", "/ci/deny.toml"]);
+            policy
+        }
+        None if has_deny_toml => "deny.toml".to_string(),
+        None => "cargo-deny defaults".to_string(),
+    };
+    args.extend(["check", "licenses", "bans", "sources"]);
+
+    let output = container.with_exec(args).stdout().await?;
+
+    Ok(format!("[deny] Licenses, bans and sources passed ({policy}).\n{output}"))
+}
@@ -1,8 +1,15 @@
 use dagger_sdk::{Directory, Query};
 
+use crate::containers::{self, BaseOpts};
+
 /// Deploy to dev server via SSH (rsync + build + restart).
 /// Requires SSHPASS environment variable.
-pub async fn run(client: &Query, source: Directory, host: &str) -> eyre::Result<String> {
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    host: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
     let password = std::env::var("SSHPASS").unwrap_or_default();
     if password.is_empty() {
         return Err(eyre::eyre!("SSHPASS environment variable not set"));
@@ -44,11 +51,11 @@ echo "Deploy complete."
     let output = client
         .container()
         .from("debian:bookworm-slim")
-        .with_exec(vec!["apt-get", "update"])
-        .with_exec(vec![
-            "apt-get", "install", "-y",
-            "sshpass", "rsync", "openssh-client", "curl",
-        ])
+        .with_exec(containers::retried(&["apt-get", "update"], opts))
+        .with_exec(containers::retried(
+            &["apt-get", "install", "-y", "sshpass", "rsync", "openssh-client", "curl"],
+            opts,
+        ))
         .with_secret_variable("SSHPASS", ssh_password)
         .with_workdir("/deploy")
         .with_directory("/deploy/source", source)
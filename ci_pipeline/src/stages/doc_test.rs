@@ -0,0 +1,16 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Run the workspace doc tests, which neither `cargo test --lib` nor nextest cover.
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let base = containers::rust_base(client, source, opts);
+    containers::ensure_free_disk(&base, opts).await?;
+
+    let output = base
+        .with_exec(vec!["cargo", "test", "--workspace", "--doc"])
+        .stdout()
+        .await?;
+
+    Ok(format!("[doc-test] Doc tests passed.\n{output}"))
+}
@@ -0,0 +1,39 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Builds the workspace docs and copies them out of the `cargo-target` cache mount,
+/// whose contents can't be returned as a directory.
+const DOCS_SCRIPT: &str = r#"
+set -e
+cargo doc --workspace --no-deps
+rm -rf /tmp/doc
+cp -r "$CARGO_TARGET_DIR/doc" /tmp/doc
+"#;
+
+/// `cargo doc --workspace --no-deps` with rustdoc warnings, broken intra-doc links
+/// included, denied; the generated HTML, one directory per crate, ready to publish.
+pub fn docs(client: &Query, source: Directory, opts: &BaseOpts) -> Directory {
+    containers::rust_base(client, source, opts)
+        .with_env_variable("RUSTDOCFLAGS", "-D warnings")
+        .with_exec(vec!["bash", "-c", DOCS_SCRIPT])
+        .directory("/tmp/doc")
+}
+
+/// Build the docs (see `docs`) and export them to `output` on the host.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    docs(client, source, opts)
+        .export(output)
+        .await
+        .map_err(|e| eyre::eyre!("[docs] rustdoc reported warnings or broken links:\n{e}"))?;
+
+    Ok(format!("[docs] Workspace docs exported to {output}."))
+}
@@ -0,0 +1,210 @@
+use std::collections::BTreeMap;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use clap::Args;
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// One run of the unit tests: the libtest thread count, and the shuffle seed when the
+/// order is randomized.
+#[derive(Clone, Copy, Debug)]
+pub struct RunConfig {
+    pub threads: usize,
+    pub shuffle_seed: Option<u64>,
+}
+
+impl RunConfig {
+    fn describe(&self) -> String {
+        match self.shuffle_seed {
+            Some(seed) => format!("--test-threads {} --shuffle-seed {seed}", self.threads),
+            None => format!("--test-threads {}", self.threads),
+        }
+    }
+}
+
+/// Runs the unit tests once per `threads seed` line of `$RUNS` (`-` for declaration
+/// order), writing libtest's JSON events interleaved with cargo's `Running` lines to
+/// `/ci/runs/<n>.log`. JSON output and shuffling are unstable libtest options, allowed on
+/// stable through `RUSTC_BOOTSTRAP`. A failing run doesn't stop the next one.
+const RUNS_SCRIPT: &str = r#"
+mkdir -p /ci/runs
+cargo test $PACKAGES --lib --no-run 2>&1 || exit 1
+n=0
+echo "$RUNS" | while read -r threads seed; do
+    shuffle=""
+    if [ "$seed" != "-" ]; then
+        shuffle="--shuffle --shuffle-seed $seed"
+    fi
+    cargo test $PACKAGES --lib --no-fail-fast -- -Z unstable-options --format json \
+        --test-threads "$threads" $shuffle > "/ci/runs/$n.log" 2>&1
+    n=$((n + 1))
+done
+"#;
+
+/// Outcome of each test, keyed `crate::path::to::test`, in one run.
+pub type RunResults = BTreeMap<String, bool>;
+
+/// Parse a `/ci/runs/<n>.log`: cargo's `Running unittests src/lib.rs
+/// (target/debug/deps/<crate>-<hash>)` lines name the crate of the JSON events after them.
+fn parse_run(log: &str) -> RunResults {
+    let mut results = RunResults::new();
+    let mut krate = String::new();
+    for line in log.lines().map(str::trim) {
+        if line.starts_with("Running ") {
+            let binary = line.rsplit('/').next().unwrap_or_default().trim_end_matches(')');
+            krate = binary.rsplit_once('-').map_or(binary, |(name, _)| name).to_string();
+            continue;
+        }
+        let Ok(event) = serde_json::from_str::<serde_json::Value>(line) else { continue };
+        if event["type"] != "test" {
+            continue;
+        }
+        let (Some(name), Some(outcome)) = (event["name"].as_str(), event["event"].as_str())
+        else {
+            continue;
+        };
+        match outcome {
+            "ok" => results.insert(format!("{krate}::{name}"), true),
+            "failed" | "timeout" => results.insert(format!("{krate}::{name}"), false),
+            _ => None,
+        };
+    }
+    results
+}
+
+/// Run the unit tests of `packages` (the workspace when empty) once per config, one after
+/// another in a single container, and return each run's per-test outcomes.
+pub async fn runs(
+    client: &Query,
+    source: Directory,
+    packages: &[String],
+    configs: &[RunConfig],
+    opts: &BaseOpts,
+) -> eyre::Result<Vec<RunResults>> {
+    let scope = if packages.is_empty() {
+        "--workspace".to_string()
+    } else {
+        packages.iter().map(|p| format!("-p {p}")).collect::<Vec<_>>().join(" ")
+    };
+    let lines: Vec<String> = configs
+        .iter()
+        .map(|c| match c.shuffle_seed {
+            Some(seed) => format!("{} {seed}", c.threads),
+            None => format!("{} -", c.threads),
+        })
+        .collect();
+
+    // Repeating an identical run is the point; never let Dagger answer it from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let ran = containers::rust_base(client, source, opts)
+        .with_env_variable("RUSTC_BOOTSTRAP", "1")
+        .with_env_variable("PACKAGES", scope)
+        .with_env_variable("RUNS", lines.join("\n"))
+        .with_env_variable("CI_TEST_RUNS", nonce.to_string())
+        .with_exec(vec!["sh", "-c", RUNS_SCRIPT]);
+    ran.sync()
+        .await
+        .map_err(|e| eyre::eyre!("building the unit tests failed:\n{e}"))?;
+
+    let logs = ran.directory("/ci/runs");
+    let mut results = Vec::with_capacity(configs.len());
+    for n in 0..configs.len() {
+        results.push(parse_run(&logs.file(format!("{n}.log")).contents().await?));
+    }
+    Ok(results)
+}
+
+/// How often and how differently `flaky-hunt` runs the tests.
+#[derive(Args, Clone, Debug)]
+pub struct HuntOpts {
+    #[arg(long, default_value_t = 10)]
+    pub iterations: usize,
+    /// libtest thread counts, cycled through across iterations
+    #[arg(long, value_delimiter = ',', default_value = "1,4,16")]
+    pub threads: Vec<usize>,
+    /// Randomize the test order of every iteration
+    #[arg(long)]
+    pub shuffle: bool,
+    /// Shuffle seed of the first iteration; fresh (and printed) when unset
+    #[arg(long)]
+    pub seed: Option<u64>,
+}
+
+/// Run the unit tests `hunt.iterations` times, cycling through `hunt.threads` for the
+/// libtest thread count and, with `hunt.shuffle`, randomizing the order from the seed
+/// plus the iteration number. Reports the tests that failed in some runs but not all,
+/// with counts and the runs they failed in, and fails when there are any, or tests that
+/// failed every run.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    hunt: &HuntOpts,
+    packages: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let iterations = hunt.iterations;
+    if iterations < 2 {
+        return Err(eyre::eyre!("[flaky-hunt] --iterations must be at least 2"));
+    }
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let seed = match hunt.seed {
+        Some(seed) => seed,
+        None => SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos() as u64,
+    };
+    let threads = if hunt.threads.is_empty() { &[1][..] } else { &hunt.threads[..] };
+    let configs: Vec<RunConfig> = (0..iterations)
+        .map(|i| RunConfig {
+            threads: threads[i % threads.len()].max(1),
+            shuffle_seed: hunt.shuffle.then(|| seed.wrapping_add(i as u64)),
+        })
+        .collect();
+
+    let results = runs(client, source, packages, &configs, opts)
+        .await
+        .map_err(|e| eyre::eyre!("[flaky-hunt] {e}"))?;
+
+    // Failing run numbers of each test that failed at least once.
+    let mut failures: BTreeMap<&str, (usize, Vec<usize>)> = BTreeMap::new();
+    for (n, run) in results.iter().enumerate() {
+        for (name, passed) in run {
+            let entry = failures.entry(name).or_default();
+            entry.0 += 1;
+            if !passed {
+                entry.1.push(n);
+            }
+        }
+    }
+    failures.retain(|_, (_, failed)| !failed.is_empty());
+
+    let mut report = String::new();
+    for (n, (config, run)) in configs.iter().zip(&results).enumerate() {
+        report.push_str(&format!("  run {n}: {}, {} test(s)\n", config.describe(), run.len()));
+    }
+    let (flaky, broken): (Vec<_>, Vec<_>) =
+        failures.iter().partition(|(_, (ran, failed))| failed.len() < *ran);
+    for (name, (ran, failed)) in &flaky {
+        let runs: Vec<String> = failed.iter().map(usize::to_string).collect();
+        report.push_str(&format!(
+            "  FLAKY {name}: failed {}/{ran} (runs {})\n",
+            failed.len(),
+            runs.join(", ")
+        ));
+    }
+    for (name, (ran, _)) in &broken {
+        report.push_str(&format!("  FAILED {name}: failed {ran}/{ran}\n"));
+    }
+
+    if !failures.is_empty() {
+        return Err(eyre::eyre!(
+            "[flaky-hunt] {} flaky and {} consistently failing test(s) over {iterations} runs \
+             (seed {seed}).\n{report}",
+            flaky.len(),
+            broken.len()
+        ));
+    }
+
+    Ok(format!("[flaky-hunt] No test failed over {iterations} runs (seed {seed}).\n{report}"))
+}
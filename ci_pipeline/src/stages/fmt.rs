@@ -1,13 +1,45 @@
 use dagger_sdk::{Directory, Query};
 
-use crate::containers;
+use crate::containers::{self, BaseOpts};
 
-/// Run `cargo fmt --workspace --check` to verify formatting.
-pub async fn run(client: &Query, source: Directory) -> eyre::Result<String> {
-    let output = containers::rust_base(client, source)
-        .with_exec(vec!["cargo", "fmt", "--workspace", "--check"])
+/// Check formatting of every workspace package with `cargo fmt --all -- --check`.
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let toolchain = containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(&["rustup", "component", "add", "rustfmt"], opts));
+
+    let output = containers::with_source(toolchain, source)
+        .with_exec(vec!["cargo", "fmt", "--all", "--", "--check"])
         .stdout()
         .await?;
 
     Ok(format!("[fmt] Format check passed.\n{output}"))
 }
+
+/// Apply `cargo fmt --all` (and `cargo clippy --fix` when `clippy_fix` is set) and
+/// export the rewritten source tree, without `target/`, to `output`.
+pub async fn fix(
+    client: &Query,
+    source: Directory,
+    clippy_fix: bool,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let toolchain = containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(&["rustup", "component", "add", "rustfmt", "clippy"], opts));
+
+    let mut fixed = containers::with_source(toolchain, source);
+    if clippy_fix {
+        fixed = fixed.with_exec(vec![
+            "cargo", "clippy", "--fix", "--allow-dirty", "--allow-no-vcs", "--workspace",
+        ]);
+    }
+    fixed
+        .with_exec(vec!["cargo", "fmt", "--all"])
+        .directory("/app")
+        .without_directory("target")
+        .export(output)
+        .await?;
+
+    let applied = if clippy_fix { "cargo fmt + clippy --fix" } else { "cargo fmt" };
+    Ok(format!("[fmt-fix] Applied {applied}; source exported to {output}."))
+}
@@ -0,0 +1,44 @@
+use dagger_sdk::{Container, Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::tailwind;
+
+/// Frontend sources, relative to the workspace root.
+const STATIC_DIR: &str = "erp_web/static";
+
+/// `node_base` over erp_web/static with `npm ci` done.
+fn installed(client: &Query, source: &Directory, opts: &BaseOpts) -> Container {
+    containers::node_base(client, source.directory(STATIC_DIR))
+        .with_exec(containers::retried(&["npm", "ci"], opts))
+}
+
+/// Build the frontend: Tailwind CSS, then the package's own `build` script if it has one.
+pub async fn build(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let output = tailwind::compile(installed(client, &source, opts))
+        .with_exec(vec!["npm", "run", "build", "--if-present"])
+        .stdout()
+        .await?;
+
+    Ok(format!("[frontend-build] Frontend build complete.\n{output}"))
+}
+
+/// Lint the frontend with eslint and check formatting with prettier.
+pub async fn lint(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let output = installed(client, &source, opts)
+        .with_exec(vec!["npx", "eslint", "."])
+        .with_exec(vec!["npx", "prettier", "--check", "."])
+        .stdout()
+        .await?;
+
+    Ok(format!("[frontend-lint] eslint and prettier passed.\n{output}"))
+}
+
+/// Run the package's `test` script, if it has one.
+pub async fn test(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let output = installed(client, &source, opts)
+        .with_exec(vec!["npm", "run", "test", "--if-present"])
+        .stdout()
+        .await?;
+
+    Ok(format!("[frontend-test] Frontend tests passed.\n{output}"))
+}
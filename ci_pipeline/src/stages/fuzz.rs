@@ -0,0 +1,72 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts, RustChannel};
+
+/// Fuzzes `$TARGET` for `$FUZZ_SECONDS` over the cached corpus, recording the exit status
+/// in `/ci/status` and copying any crash artifacts to `/ci/artifacts`, so they can be
+/// exported before the stage fails.
+const FUZZ_SCRIPT: &str = r#"
+mkdir -p /ci/artifacts
+cargo fuzz run "$TARGET" "fuzz/corpus/$TARGET" -- -max_total_time="$FUZZ_SECONDS" 2>&1
+echo $? > /ci/status
+cp -r "fuzz/artifacts/$TARGET/." /ci/artifacts/ 2>/dev/null || true
+"#;
+
+/// Run the cargo-fuzz target `target` (under `fuzz/`) with libFuzzer for `seconds` on
+/// the nightly toolchain. The corpus lives in a cache volume per target, so every run
+/// builds on the inputs earlier runs found. Crash artifacts are exported to `output`
+/// and fail the stage.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    target: &str,
+    seconds: u64,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let valid = |c: char| c.is_ascii_alphanumeric() || c == '_' || c == '-';
+    if target.is_empty() || !target.chars().all(valid) {
+        return Err(eyre::eyre!("[fuzz] invalid fuzz target name '{target}'"));
+    }
+    let opts = BaseOpts {
+        rust_channel: RustChannel::Nightly,
+        // A prebuilt image carries its own toolchain, not nightly.
+        base_image: None,
+        target_subdir: Some("fuzz".to_string()),
+        ..opts.clone()
+    };
+
+    let toolchain = containers::rust_toolchain(client, &opts)
+        .with_exec(containers::retried(&["cargo", "install", "cargo-fuzz", "--locked"], &opts));
+
+    // Fuzzing is never answered from cache: each run extends the corpus.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let fuzzed = containers::with_source(toolchain, source)
+        .with_mounted_cache(
+            format!("/app/fuzz/corpus/{target}"),
+            client.cache_volume(format!("fuzz-corpus-{target}")),
+        )
+        .with_env_variable("TARGET", target)
+        .with_env_variable("FUZZ_SECONDS", seconds.to_string())
+        .with_env_variable("CI_FUZZ_RUN", nonce.to_string())
+        .with_exec(vec!["bash", "-c", FUZZ_SCRIPT]);
+    let log = fuzzed.stdout().await?;
+    // libFuzzer logs every new coverage point; its final stats and any crash are last.
+    let lines: Vec<&str> = log.lines().collect();
+    let tail = lines[lines.len().saturating_sub(40)..].join("\n");
+
+    if fuzzed.file("/ci/status").contents().await?.trim() != "0" {
+        let artifacts = fuzzed.directory("/ci/artifacts");
+        let crashes = artifacts.entries().await?;
+        artifacts.export(output).await?;
+        return Err(eyre::eyre!(
+            "[fuzz] {target} failed; {} artifact(s) exported to {output}: {}\n{tail}",
+            crashes.len(),
+            crashes.join(", "),
+        ));
+    }
+
+    Ok(format!("[fuzz] {target} ran {seconds}s without crashing.\n{tail}"))
+}
@@ -0,0 +1,177 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use clap::Args;
+use dagger_sdk::{Container, Directory, File, Query, Secret};
+
+const HELM_IMAGE: &str = "alpine/helm:3.16.3";
+const CURL_IMAGE: &str = "curlimages/curl:8.11.1";
+
+/// Chart location in the workspace when none is given.
+pub const DEFAULT_CHART: &str = "deploy/chart";
+
+/// Where the kubeconfig secret is mounted.
+const KUBECONFIG: &str = "/run/secrets/kubeconfig";
+
+/// `helm` with `chart` from `source` at `/chart`.
+fn helm(client: &Query, source: &Directory, chart: &str) -> Container {
+    client
+        .container()
+        .from(HELM_IMAGE)
+        .with_directory("/chart", source.directory(chart))
+        .with_workdir("/chart")
+}
+
+/// Host variable holding the chart registry password for `chart-package --push`.
+const REGISTRY_PASSWORD_ENV: &str = "HELM_REGISTRY_PASSWORD";
+
+/// Logs in to the registry of `$PUSH` (`oci://host/path`) and pushes every packaged
+/// chart in `/out` there.
+const PUSH_SCRIPT: &str = r#"
+set -e
+host=${PUSH#oci://}
+host=${host%%/*}
+printf '%s' "$HELM_REGISTRY_PASSWORD" | helm registry login "$host" -u "$HELM_USER" --password-stdin
+for chart in /out/*.tgz; do
+    helm push "$chart" "$PUSH"
+done
+"#;
+
+/// `helm lint --strict` on `chart`, failing on warnings as well as errors.
+pub async fn lint(client: &Query, source: Directory, chart: &str) -> eyre::Result<String> {
+    let output = helm(client, &source, chart)
+        .with_exec(vec!["helm", "lint", "--strict", "."])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[chart-lint] {chart} failed helm lint:\n{e}"))?;
+    Ok(format!("[chart-lint] {chart} passed.\n{output}"))
+}
+
+/// Where `chart-package` pushes the packaged chart.
+#[derive(Args, Clone, Debug)]
+pub struct ChartPush {
+    /// OCI registry path to push to, e.g. oci://ghcr.io/centrix/charts
+    #[arg(long = "push", requires = "username")]
+    pub url: Option<String>,
+    /// Registry user; the password is read from HELM_REGISTRY_PASSWORD
+    #[arg(long = "push-username")]
+    pub username: Option<String>,
+}
+
+/// Lint and `helm package` `chart`, overriding its `version`/`appVersion` when given,
+/// export the archive into `output`, and push it to `push.url` when set.
+pub async fn package(
+    client: &Query,
+    source: Directory,
+    chart: &str,
+    version: Option<&str>,
+    app_version: Option<&str>,
+    output: &str,
+    push: &ChartPush,
+) -> eyre::Result<String> {
+    let linted = lint(client, source.clone(), chart).await?;
+
+    let mut cmd = vec!["helm", "package", ".", "--destination", "/out"];
+    if let Some(version) = version {
+        cmd.extend(["--version", version]);
+    }
+    if let Some(app_version) = app_version {
+        cmd.extend(["--app-version", app_version]);
+    }
+    let packaged = helm(client, &source, chart).with_exec(cmd);
+    let archives = packaged.directory("/out");
+    let names = archives.entries().await?;
+    archives.export(output).await?;
+    let mut summary =
+        format!("{linted}\n[chart-package] {} exported to {output}.", names.join(", "));
+
+    if let Some(url) = &push.url {
+        let password = std::env::var(REGISTRY_PASSWORD_ENV).unwrap_or_default();
+        if password.is_empty() {
+            return Err(eyre::eyre!("[chart-package] {REGISTRY_PASSWORD_ENV} is not set"));
+        }
+        // Pushing is a side effect; never let Dagger answer it from cache.
+        let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+        packaged
+            .with_secret_variable(
+                "HELM_REGISTRY_PASSWORD",
+                client.set_secret("helm-registry-password", password),
+            )
+            .with_env_variable("HELM_USER", push.username.as_deref().unwrap_or_default())
+            .with_env_variable("PUSH", url.as_str())
+            .with_env_variable("CI_CHART_PUSH", nonce.to_string())
+            .with_exec(vec!["sh", "-c", PUSH_SCRIPT])
+            .sync()
+            .await
+            .map_err(|e| eyre::eyre!("[chart-package] Pushing to {url} failed:\n{e}"))?;
+        summary.push_str(&format!("\nPushed to {url}."));
+    }
+    Ok(summary)
+}
+
+/// Helm release the chart is installed as.
+#[derive(Args, Clone, Debug)]
+pub struct HelmRelease {
+    /// Release name
+    #[arg(long = "release", default_value = "erp")]
+    pub name: String,
+    #[arg(long, default_value = "staging")]
+    pub namespace: String,
+    /// Image tag pushed by `publish`, set as the chart's `image.tag`
+    #[arg(long)]
+    pub image_tag: String,
+}
+
+/// `helm upgrade --install` `chart` as `release` into the cluster of `kubeconfig`, with
+/// the `values` overlay on top of the chart's `values.yaml`. Waits for the workloads to
+/// become ready, rolling back if they don't, then polls `url`'s `/health` for up to a
+/// minute.
+pub async fn deploy_staging(
+    client: &Query,
+    source: Directory,
+    chart: &str,
+    release: &HelmRelease,
+    values: Option<File>,
+    kubeconfig: Secret,
+    url: &str,
+) -> eyre::Result<String> {
+    let image_tag = format!("image.tag={}", release.image_tag);
+    let mut cmd = vec![
+        "helm", "upgrade", "--install", release.name.as_str(), ".",
+        "--namespace", release.namespace.as_str(), "--create-namespace",
+        "--set", image_tag.as_str(), "--wait", "--atomic", "--timeout", "5m",
+    ];
+    let mut container = helm(client, &source, chart)
+        .with_mounted_secret(KUBECONFIG, kubeconfig)
+        .with_env_variable("KUBECONFIG", KUBECONFIG);
+    if let Some(values) = values {
+        container = container.with_file("/ci/values.yaml", values);
+        cmd.extend(["--values", "/ci/values.yaml"]);
+    }
+
+    // Deploying is a side effect; never let Dagger answer it from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let deployed = container
+        .with_env_variable("CI_DEPLOY_RUN", nonce.to_string())
+        .with_exec(cmd)
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[deploy-staging] helm upgrade failed (rolled back):\n{e}"))?;
+
+    let health = format!("{}/health", url.trim_end_matches('/'));
+    client
+        .container()
+        .from(CURL_IMAGE)
+        .with_env_variable("CI_DEPLOY_RUN", nonce.to_string())
+        .with_exec(vec![
+            "curl", "-fsS", "--retry", "30", "--retry-delay", "2", "--retry-all-errors",
+            "-o", "/dev/null", health.as_str(),
+        ])
+        .sync()
+        .await
+        .map_err(|e| eyre::eyre!("[deploy-staging] Smoke check of {health} failed:\n{e}"))?;
+
+    Ok(format!(
+        "[deploy-staging] {} deployed to {} with image tag {}; {health} is up.\n{deployed}",
+        release.name, release.namespace, release.image_tag
+    ))
+}
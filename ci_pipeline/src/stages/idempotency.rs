@@ -0,0 +1,79 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+const TEST_SCRIPT: &str = r#"
+set -euo pipefail
+
+BINARY="./target/release/erp-server"
+
+count() {
+    psql "$DATABASE_URL" -At -c "SELECT COUNT(*) FROM ir_model_data WHERE module = '$MODULE'"
+}
+duplicates() {
+    psql "$DATABASE_URL" -At -c "SELECT COUNT(*) FROM (SELECT name FROM ir_model_data WHERE module = '$MODULE' GROUP BY name HAVING COUNT(*) > 1) d"
+}
+
+echo "=== Install Idempotency Test: $MODULE ==="
+
+echo "[1/5] Running migrations..."
+$BINARY migrate 2>&1
+
+echo "[2/5] Seeding base data..."
+$BINARY seed 2>&1
+
+echo "[3/5] Installing $MODULE..."
+$BINARY module install base 2>&1
+if [ "$MODULE" != "base" ]; then
+    $BINARY module install "$MODULE" 2>&1
+fi
+BEFORE=$(count)
+
+echo "[4/5] Installing $MODULE again..."
+if ! $BINARY module install "$MODULE" 2>&1; then
+    echo "FAIL: second install of $MODULE returned an error"
+    exit 1
+fi
+AFTER=$(count)
+DUPLICATES=$(duplicates)
+
+echo "[5/5] Comparing ir_model_data rows..."
+echo "Before: $BEFORE, after: $AFTER, duplicated names: $DUPLICATES"
+if [ "$AFTER" != "$BEFORE" ]; then
+    echo "FAIL: second install changed the ir_model_data row count"
+    exit 1
+fi
+if [ "$DUPLICATES" != "0" ]; then
+    echo "FAIL: second install created duplicate ir_model_data rows"
+    exit 1
+fi
+
+echo ""
+echo "=== Install Idempotency Test Complete ==="
+"#;
+
+/// Install `module`, install it again, and assert the second install succeeds
+/// without adding or duplicating `ir_model_data` rows.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    module: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    if module.is_empty() || !module.chars().all(|c| c.is_ascii_alphanumeric() || c == '_') {
+        return Err(eyre::eyre!("invalid module name '{module}'"));
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+
+    let output = integration::server_env(client, source, pg, containers::pg_url(client), opts)
+        .with_env_variable("MODULE", module)
+        .with_exec(vec!["bash", "-c", TEST_SCRIPT])
+        .stdout()
+        .await?;
+
+    Ok(format!("[install-idempotency] {output}"))
+}
@@ -0,0 +1,69 @@
+use dagger_sdk::{Container, Directory, File, Query};
+
+use crate::containers::BaseOpts;
+use crate::stages::publish;
+
+const TRIVY_IMAGE: &str = "aquasec/trivy:0.58.1";
+
+/// Allowlist picked up from the workspace root when none is passed.
+const DEFAULT_ALLOWLIST: &str = ".trivyignore";
+
+/// Scan `image` with Trivy, failing on fixed or unfixed CRITICAL/HIGH CVEs that are
+/// not listed in `allowlist` (a `.trivyignore` file of CVE IDs). The vulnerability
+/// database is kept in a cache volume between runs.
+pub async fn scan(
+    client: &Query,
+    image: &Container,
+    allowlist: Option<File>,
+) -> eyre::Result<String> {
+    let mut trivy = client
+        .container()
+        .from(TRIVY_IMAGE)
+        .with_mounted_cache("/root/.cache/trivy", client.cache_volume("trivy-db"))
+        .with_file("/ci/image.tar", image.as_tarball());
+    let mut cmd = vec![
+        "trivy", "image", "--input", "/ci/image.tar",
+        "--severity", "CRITICAL,HIGH", "--exit-code", "1", "--no-progress",
+    ];
+    if let Some(file) = allowlist {
+        trivy = trivy.with_file("/ci/trivyignore", file);
+        cmd.extend(["--ignorefile", "/ci/trivyignore"]);
+    }
+
+    let output = trivy
+        .with_exec(cmd)
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("CRITICAL/HIGH vulnerabilities found:\n{e}"))?;
+    Ok(output)
+}
+
+/// `allowlist`, or the workspace's `.trivyignore` when there is one.
+pub async fn allowlist_or_default(
+    source: &Directory,
+    allowlist: Option<File>,
+) -> eyre::Result<Option<File>> {
+    if allowlist.is_some() {
+        return Ok(allowlist);
+    }
+    let found = !source.glob(DEFAULT_ALLOWLIST).await?.is_empty();
+    Ok(found.then(|| source.file(DEFAULT_ALLOWLIST)))
+}
+
+/// Build the amd64 runtime image and scan it with Trivy; see `scan`. Without
+/// `allowlist`, the workspace's `.trivyignore` is used if present.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    allowlist: Option<File>,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let allowlist = allowlist_or_default(&source, allowlist).await?;
+    let image = publish::runtime_image(client, source, "linux/amd64", opts)?;
+
+    let output = scan(client, &image, allowlist)
+        .await
+        .map_err(|e| eyre::eyre!("[image-scan] {e}"))?;
+
+    Ok(format!("[image-scan] No unallowed CRITICAL/HIGH vulnerabilities.\n{output}"))
+}
@@ -0,0 +1,100 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::manifest;
+use crate::stages::integration;
+
+const TEST_SCRIPT: &str = r#"
+set -euo pipefail
+
+BINARY="./target/release/erp-server"
+
+echo "=== Install Order Test: $MODULE ==="
+
+echo "[1/4] Running migrations..."
+$BINARY migrate 2>&1
+
+echo "[2/4] Seeding base data..."
+$BINARY seed 2>&1
+
+echo "[3/4] Installing $MODULE with its dependencies..."
+$BINARY module install "$MODULE" 2>&1
+
+echo "[4/4] Reading install order..."
+psql "$DATABASE_URL" -At -c "SELECT 'INSTALLED ' || name FROM ir_module_module WHERE state = 'installed' ORDER BY install_date"
+psql "$DATABASE_URL" -At -c "SELECT 'TIED ' || string_agg(name, ' ' ORDER BY name) FROM ir_module_module WHERE state = 'installed' GROUP BY install_date HAVING COUNT(*) > 1"
+"#;
+
+/// Install `module` and assert, via `ir_module_module.install_date`, that each of its
+/// dependencies (from the manifests) was installed before the module depending on it.
+/// Modules sharing an `install_date` have no observable order, so a dependency tied
+/// with its dependent fails the stage rather than being broken arbitrarily; ties between
+/// unrelated modules are fine.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    module: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let manifests = manifest::load_all(&source).await?;
+    if !manifests.contains_key(module) {
+        return Err(eyre::eyre!("no manifest found for module '{module}'"));
+    }
+    let expected = manifest::install_order(&manifests, module)?;
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    let output = integration::server_env(client, source, pg, containers::pg_url(client), opts)
+        .with_env_variable("MODULE", module)
+        .with_exec(vec!["bash", "-c", TEST_SCRIPT])
+        .stdout()
+        .await?;
+
+    let observed: Vec<&str> = output
+        .lines()
+        .filter_map(|line| line.strip_prefix("INSTALLED "))
+        .filter(|name| expected.iter().any(|m| m == name))
+        .collect();
+
+    let tied: Vec<Vec<&str>> = output
+        .lines()
+        .filter_map(|line| line.strip_prefix("TIED "))
+        .map(|names| names.split_whitespace().collect())
+        .collect();
+
+    let mut violations = Vec::new();
+    for name in &expected {
+        let Some(pos) = observed.iter().position(|m| m == name) else {
+            violations.push(format!("{name} was not installed"));
+            continue;
+        };
+        if let Some(manifest) = manifests.get(name) {
+            for dep in &manifest.module.depends {
+                let same_date =
+                    tied.iter().any(|t| t.contains(&name.as_str()) && t.contains(&dep.as_str()));
+                match observed.iter().position(|m| m == dep) {
+                    _ if same_date => violations
+                        .push(format!("{dep} and {name} share an install_date; order unknown")),
+                    Some(dep_pos) if dep_pos < pos => {}
+                    _ => violations.push(format!("{dep} was not installed before {name}")),
+                }
+            }
+        }
+    }
+
+    let report = format!(
+        "Expected order: {}\nObserved order: {}\n",
+        expected.join(" -> "),
+        observed.join(" -> ")
+    );
+    if !violations.is_empty() {
+        return Err(eyre::eyre!(
+            "[install-order] Dependency order violated:\n  - {}\n{report}{output}",
+            violations.join("\n  - ")
+        ));
+    }
+
+    Ok(format!("[install-order] Dependencies installed first.\n{report}{output}"))
+}
@@ -1,69 +1,608 @@
-use dagger_sdk::{Directory, Query};
+use std::time::{Instant, SystemTime, UNIX_EPOCH};
 
-use crate::containers;
+use clap::{Args, ValueEnum};
+use dagger_sdk::{Container, Directory, Query, Secret, Service};
 
-/// Run module lifecycle integration test against a fresh PostgreSQL database.
-/// Flow: migrate -> seed -> install base -> install todo_list -> verify -> uninstall -> verify cleanup
-pub async fn run(client: &Query, source: Directory) -> eyre::Result<String> {
-    let pg = containers::postgres(client);
-    let db_url = "postgres://erp:erp_password@db:5432/erp_test";
+use crate::containers::{self, BaseOpts};
 
-    let test_script = r#"
-set -euo pipefail
+/// How much the lifecycle test prints.
+#[derive(Clone, Copy, Debug, Default, ValueEnum)]
+pub enum Verbosity {
+    /// Step results, plus output only for a failing step.
+    Quiet,
+    /// Step output at `RUST_LOG=info`.
+    #[default]
+    Normal,
+    /// `RUST_LOG=debug`, echoed SQL, per-step timing and `ir_model_data` dumps on each
+    /// verification.
+    Debug,
+}
 
-export DATABASE_URL="postgres://erp:erp_password@db:5432/erp_test"
-export RUST_LOG=info
-BINARY="./target/release/erp-server"
+impl Verbosity {
+    fn rust_log(self) -> &'static str {
+        match self {
+            Verbosity::Quiet => "error",
+            Verbosity::Normal => "info",
+            Verbosity::Debug => "debug",
+        }
+    }
+}
 
-echo "=== Integration Test: Module Lifecycle ==="
+const BINARY: &str = "./target/release/erp-server";
+const CURL_IMAGE: &str = "curlimages/curl:8.11.1";
 
-echo "[1/8] Running migrations..."
-$BINARY migrate 2>&1 || true
+/// Services bound next to PostgreSQL for code paths that need them.
+#[derive(Args, Clone, Debug, Default)]
+pub struct Services {
+    /// Bind Redis as host `redis`, with `REDIS_URL` set, for the job queue and cache
+    #[arg(long)]
+    pub redis: bool,
+    /// Bind MinIO as host `minio` with the attachments bucket created, and `S3_ENDPOINT`,
+    /// `S3_BUCKET`, `S3_REGION` and the AWS key variables set
+    #[arg(long)]
+    pub minio: bool,
+    /// Bind Mailpit as host `mail`, with `SMTP_HOST` and `SMTP_PORT` set, and check the
+    /// password-reset and notification emails the served server sends
+    #[arg(long)]
+    pub mail: bool,
+}
 
-echo "[2/8] Seeding base data..."
-$BINARY seed 2>&1 || true
+impl Services {
+    /// `container` with every requested service started, bound and wired up through
+    /// its environment.
+    pub async fn bind(&self, client: &Query, container: Container) -> eyre::Result<Container> {
+        let mut container = container;
+        if self.redis {
+            let redis = containers::redis(client);
+            containers::wait_for_service(&redis, "redis", containers::SERVICE_READY_TIMEOUT).await?;
+            container = container.with_service_binding("redis", redis).with_env_variable(
+                "REDIS_URL",
+                format!("redis://redis:{}/0", containers::REDIS_PORT),
+            );
+        }
+        if self.minio {
+            let minio = containers::minio(client);
+            containers::wait_for_service(&minio, "minio", containers::SERVICE_READY_TIMEOUT)
+                .await?;
+            let endpoint = format!("http://minio:{}", containers::MINIO_PORT);
+            let [access_key, secret_key] = containers::minio_keys(client);
+            create_bucket(client, &minio, &endpoint, [access_key.clone(), secret_key.clone()])
+                .await?;
+            container = container
+                .with_service_binding("minio", minio)
+                .with_env_variable("S3_ENDPOINT", endpoint)
+                .with_env_variable("S3_BUCKET", containers::MINIO_BUCKET)
+                .with_env_variable("S3_REGION", "us-east-1")
+                .with_secret_variable("AWS_ACCESS_KEY_ID", access_key)
+                .with_secret_variable("AWS_SECRET_ACCESS_KEY", secret_key);
+        }
+        if self.mail {
+            let mailpit = containers::mailpit(client);
+            containers::wait_for_service(&mailpit, "mail", containers::SERVICE_READY_TIMEOUT)
+                .await?;
+            container = container
+                .with_service_binding("mail", mailpit)
+                .with_env_variable("SMTP_HOST", "mail")
+                .with_env_variable("SMTP_PORT", containers::MAILPIT_SMTP_PORT.to_string());
+        }
+        Ok(container)
+    }
+}
 
-echo "[3/8] Installing base module..."
-$BINARY module install base 2>&1 || true
+/// Create `MINIO_BUCKET` in the running `minio` with the MinIO client.
+async fn create_bucket(
+    client: &Query,
+    minio: &Service,
+    endpoint: &str,
+    [access_key, secret_key]: [Secret; 2],
+) -> eyre::Result<()> {
+    // The bucket lives in this run's fresh service; never let Dagger answer it from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    client
+        .container()
+        .from("minio/mc:RELEASE.2024-11-05T11-29-45Z")
+        .with_service_binding("minio", minio.clone())
+        .with_env_variable("CI_BUCKET_RUN", nonce.to_string())
+        .with_env_variable("ENDPOINT", endpoint)
+        .with_env_variable("BUCKET", containers::MINIO_BUCKET)
+        .with_secret_variable("ACCESS_KEY", access_key)
+        .with_secret_variable("SECRET_KEY", secret_key)
+        .with_exec(vec![
+            "sh", "-c",
+            "mc alias set ci \"$ENDPOINT\" \"$ACCESS_KEY\" \"$SECRET_KEY\" \
+             && mc mb --ignore-existing \"ci/$BUCKET\"",
+        ])
+        .sync()
+        .await
+        .map_err(|e| eyre::eyre!("creating the {} bucket failed: {e}", containers::MINIO_BUCKET))?;
+    Ok(())
+}
 
-echo "[4/8] Installing todo_list module..."
-$BINARY module install todo_list 2>&1 || true
+/// `psql` against `$DATABASE_URL` with the output flags in `$0` and the query in `$1`.
+const PSQL: &str = r#"psql "$DATABASE_URL" -v ON_ERROR_STOP=1 "$0" -c "$1""#;
 
-echo "[5/8] Verifying todo_list records..."
-RECORD_COUNT=$(psql "$DATABASE_URL" -t -c "SELECT COUNT(*) FROM ir_model_data WHERE module = 'todo_list'" 2>/dev/null | tr -d ' ')
-echo "todo_list records: $RECORD_COUNT"
+/// Expected value of a verification query.
+#[derive(Clone, Copy)]
+enum Expect {
+    Positive,
+    Zero,
+    True,
+}
 
-echo "[6/8] Verifying todo_task table..."
-TABLE_EXISTS=$(psql "$DATABASE_URL" -t -c "SELECT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'todo_task')" 2>/dev/null | tr -d ' ')
-echo "todo_task table exists: $TABLE_EXISTS"
+impl Expect {
+    fn holds(self, value: &str) -> bool {
+        match self {
+            Expect::Positive => value.parse::<i64>().is_ok_and(|n| n > 0),
+            Expect::Zero => value == "0",
+            Expect::True => value == "t",
+        }
+    }
+
+    fn describe(self) -> &'static str {
+        match self {
+            Expect::Positive => "> 0",
+            Expect::Zero => "0",
+            Expect::True => "true",
+        }
+    }
+}
+
+/// A scalar query whose result must match `expect`.
+struct Check {
+    label: String,
+    sql: String,
+    expect: Expect,
+}
+
+/// One lifecycle step: an `erp-server` subcommand, a shell script, or verification
+/// queries.
+enum Action {
+    Run(Vec<String>),
+    Script(&'static str),
+    /// Checks plus the module whose `ir_model_data` rows are dumped in debug mode.
+    Verify(Vec<Check>, String),
+}
+
+impl Action {
+    /// The exec of a `Run` or `Script` step; empty for `Verify`.
+    fn command(&self) -> Vec<String> {
+        match self {
+            Action::Run(args) => std::iter::once(BINARY.to_string()).chain(args.clone()).collect(),
+            Action::Script(script) => vec!["sh".into(), "-c".into(), script.to_string()],
+            Action::Verify(..) => Vec::new(),
+        }
+    }
+}
 
-echo "[7/8] Uninstalling todo_list module..."
-$BINARY module uninstall todo_list 2>&1 || true
+pub struct Step {
+    label: String,
+    action: Action,
+}
+
+impl Step {
+    fn run(label: String, args: &[&str]) -> Self {
+        Step { label, action: Action::Run(args.iter().map(|a| a.to_string()).collect()) }
+    }
+
+    fn script(label: String, script: &'static str) -> Self {
+        Step { label, action: Action::Script(script) }
+    }
+
+    fn verify(label: String, checks: Vec<Check>, module: &str) -> Self {
+        Step { label, action: Action::Verify(checks, module.to_string()) }
+    }
+}
+
+/// `ir_model_data` row count of `module`, expected positive while installed, zero after.
+fn records_check(module: &str, expect: Expect) -> Check {
+    Check {
+        label: format!("{module} records"),
+        sql: format!("SELECT COUNT(*) FROM ir_model_data WHERE module = '{module}'"),
+        expect,
+    }
+}
+
+/// Lifecycle of `module` from an empty database: migrate, seed, install base and the
+/// module, verify its records and `tables`, optionally upgrade and re-verify, then
+/// uninstall and verify the records and tables are gone.
+pub fn lifecycle_steps(module: &str, tables: &[String], upgrade: bool) -> Vec<Step> {
+    let table_exists = |table: &str| {
+        format!(
+            "SELECT EXISTS (SELECT 1 FROM information_schema.tables \
+             WHERE table_name = '{table}')"
+        )
+    };
+    let installed = || {
+        let mut checks = vec![records_check(module, Expect::Positive)];
+        checks.extend(tables.iter().map(|table| Check {
+            label: format!("{table} table exists"),
+            sql: table_exists(table),
+            expect: Expect::True,
+        }));
+        checks
+    };
+
+    let mut steps = vec![
+        Step::run("Running migrations".into(), &["migrate"]),
+        Step::run("Seeding base data".into(), &["seed"]),
+        Step::run("Installing base module".into(), &["module", "install", "base"]),
+    ];
+    if module != "base" {
+        steps.push(Step::run(
+            format!("Installing {module} module"),
+            &["module", "install", module],
+        ));
+    }
+    steps.push(Step::verify(format!("Verifying {module} install"), installed(), module));
+    if upgrade {
+        steps.push(Step::run(
+            format!("Upgrading {module} module"),
+            &["module", "upgrade", module],
+        ));
+        steps.push(Step::verify(format!("Verifying {module} upgrade"), installed(), module));
+    }
+    steps.push(Step::run(
+        format!("Uninstalling {module} module"),
+        &["module", "uninstall", module],
+    ));
+
+    let mut cleanup = vec![records_check(module, Expect::Zero)];
+    cleanup.extend(tables.iter().map(|table| Check {
+        label: format!("{table} table dropped"),
+        sql: format!("SELECT NOT ({})", table_exists(table)),
+        expect: Expect::True,
+    }));
+    steps.push(Step::verify("Verifying cleanup".into(), cleanup, module));
+    steps
+}
+
+/// Install every module in `order` (dependencies first) on top of base, verifying each,
+/// then uninstall them in reverse order, verifying each module's records are removed.
+pub fn all_modules_steps(order: &[String]) -> Vec<Step> {
+    let modules: Vec<&str> = order.iter().map(String::as_str).filter(|m| *m != "base").collect();
+
+    let mut steps = vec![
+        Step::run("Running migrations".into(), &["migrate"]),
+        Step::run("Seeding base data".into(), &["seed"]),
+        Step::run("Installing base module".into(), &["module", "install", "base"]),
+    ];
+    for module in &modules {
+        steps.push(Step::run(format!("Installing {module}"), &["module", "install", module]));
+        steps.push(Step::verify(
+            format!("Verifying {module} install"),
+            vec![records_check(module, Expect::Positive)],
+            module,
+        ));
+    }
+    for module in modules.iter().rev() {
+        steps.push(Step::run(
+            format!("Uninstalling {module}"),
+            &["module", "uninstall", module],
+        ));
+        steps.push(Step::verify(
+            format!("Verifying {module} cleanup"),
+            vec![records_check(module, Expect::Zero)],
+            module,
+        ));
+    }
+    steps
+}
+
+/// The integration test's lifecycle: `todo_list` and its `todo_task` table.
+pub fn default_steps() -> Vec<Step> {
+    lifecycle_steps("todo_list", &["todo_task".to_string()], false)
+}
 
-echo "[8/8] Verifying cleanup..."
-REMAINING=$(psql "$DATABASE_URL" -t -c "SELECT COUNT(*) FROM ir_model_data WHERE module = 'todo_list'" 2>/dev/null | tr -d ' ')
-TABLE_GONE=$(psql "$DATABASE_URL" -t -c "SELECT NOT EXISTS (SELECT 1 FROM information_schema.tables WHERE table_name = 'todo_task')" 2>/dev/null | tr -d ' ')
-echo "Remaining records: $REMAINING"
-echo "Table dropped: $TABLE_GONE"
+/// Where `--fixtures` is mounted.
+const FIXTURES_DIR: &str = "/ci/fixtures";
 
-echo ""
-echo "=== Integration Test Complete ==="
+/// Loads the files in `/ci/fixtures` in name order: `*.sql` with psql, `*.csv` (with a
+/// header row) into the table named by the file, less an ordering prefix such as `01-`.
+const LOAD_FIXTURES: &str = r#"
+set -e
+cd /ci/fixtures
+for f in *; do
+    case "$f" in
+        *.sql)
+            echo "$f"
+            psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -q -f "$f"
+            ;;
+        *.csv)
+            table=$(echo "${f%.csv}" | sed -E 's/^[0-9]+-//')
+            echo "$f -> $table"
+            psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -q \
+                -c "\copy \"$table\" FROM '$f' WITH (FORMAT csv, HEADER true)"
+            ;;
+    esac
+done
 "#;
 
-    let output = containers::rust_base(client, source)
-        .with_service_binding("db", pg)
-        .with_env_variable("DATABASE_URL", db_url)
-        .with_env_variable("RUST_LOG", "info")
+/// Run the module lifecycle integration test against PostgreSQL, with `services`
+/// bound as well. `fixtures`, a directory of SQL and CSV files, is loaded right after
+/// the migrations; see `LOAD_FIXTURES`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    verbosity: Verbosity,
+    services: &Services,
+    fixtures: Option<Directory>,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    let mut env = server_env(client, source.clone(), pg, containers::pg_url(client), opts);
+    let mut steps = default_steps();
+    if let Some(fixtures) = fixtures {
+        env = env.with_directory(FIXTURES_DIR, fixtures);
+        steps.insert(1, Step::script("Loading fixtures".into(), LOAD_FIXTURES));
+    }
+    let env = services.bind(client, env).await?;
+    let output = lifecycle_in(env, &steps, verbosity).await?;
+    if !services.mail {
+        return Ok(format!("[integration] {output}"));
+    }
+
+    let emails = email_flows(client, source, services, opts).await?;
+    Ok(format!("[integration] {output}\n{emails}"))
+}
+
+/// An email flow: the request to the served server that triggers it, and the subject
+/// the captured message must contain.
+struct EmailFlow {
+    label: &'static str,
+    path: &'static str,
+    body: &'static str,
+    subject: &'static str,
+}
+
+/// Emails whose templates are checked, sent to the seeded admin.
+const EMAIL_FLOWS: &[EmailFlow] = &[
+    EmailFlow {
+        label: "Password reset",
+        path: "/api/auth/password-reset",
+        body: r#"{"email": "admin@example.com"}"#,
+        subject: "Reset your password",
+    },
+    EmailFlow {
+        label: "Notification",
+        path: "/api/notifications/test",
+        body: r#"{"email": "admin@example.com"}"#,
+        subject: "Notification",
+    },
+];
+
+/// POSTs `$BODY` to `$BASE_URL$TRIGGER`, then polls Mailpit's search API for up to 30s
+/// until a message with `$SUBJECT` is captured. Sending may go through the job queue,
+/// hence the polling.
+const EMAIL_SCRIPT: &str = r#"
+set -e
+curl -fsS -X POST "$BASE_URL$TRIGGER" -H 'Content-Type: application/json' --data "$BODY" -o /dev/null
+for _ in $(seq 30); do
+    found=$(curl -fsS -G "$MAIL_URL/api/v1/search" --data-urlencode "query=subject:\"$SUBJECT\"")
+    case "$found" in
+        *'"messages_count":0'*) sleep 1 ;;
+        *) echo "captured '$SUBJECT'"; exit 0 ;;
+    esac
+done
+echo "no message with subject '$SUBJECT' captured after 30s" >&2
+exit 1
+"#;
+
+/// Serve `erp-server` with `services` bound, trigger each of `EMAIL_FLOWS` over HTTP and
+/// assert the message reached Mailpit, one `with_exec` per flow.
+async fn email_flows(
+    client: &Query,
+    source: Directory,
+    services: &Services,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    // A database of its own: the served server migrates, seeds and installs on startup,
+    // which must not run against the database the lifecycle steps already migrated.
+    let pg = containers::ready_isolated_postgres(client, opts, "email").await?;
+    let env = server_env(client, source, pg, containers::pg_url(client), opts);
+    let script = server_script(BINARY, &[]);
+    let server = services
+        .bind(client, env)
+        .await?
+        .with_exposed_port(SERVER_PORT)
+        .with_default_args(vec!["bash".to_string(), "-c".to_string(), script])
+        .as_service();
+
+    // Emails are side effects of this run's server; never let Dagger answer from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let base_url = format!("http://erp:{SERVER_PORT}");
+    let curl = client
+        .container()
+        .from(CURL_IMAGE)
+        .with_service_binding("erp", server)
+        .with_service_binding("mail", containers::mailpit(client))
+        .with_env_variable("BASE_URL", base_url.as_str())
+        .with_env_variable("MAIL_URL", format!("http://mail:{}", containers::MAILPIT_API_PORT))
+        .with_env_variable("CI_EMAIL_RUN", nonce.to_string())
         .with_exec(vec![
-            "sh", "-c",
-            "for i in $(seq 1 30); do pg_isready -h db -p 5432 -U erp && break; sleep 1; done",
-        ])
+            "curl", "-fsS", "--retry", "120", "--retry-delay", "1", "--retry-all-errors",
+            "-o", "/dev/null", &format!("{base_url}/health"),
+        ]);
+
+    let mut report = String::from("=== Email Flows ===\n");
+    for flow in EMAIL_FLOWS {
+        let result = curl
+            .with_env_variable("TRIGGER", flow.path)
+            .with_env_variable("BODY", flow.body)
+            .with_env_variable("SUBJECT", flow.subject)
+            .with_exec(vec!["sh", "-c", EMAIL_SCRIPT])
+            .stdout()
+            .await;
+        match result {
+            Ok(output) => report.push_str(&format!("{}... ok\n{output}", flow.label)),
+            Err(e) => {
+                report.push_str(&format!("{}... FAILED\n{e}\n", flow.label));
+                return Err(eyre::eyre!(report));
+            }
+        }
+    }
+    Ok(report)
+}
+
+/// Build `erp-server` and run the lifecycle `steps` against `db`, bound as host `db`
+/// and reachable at the `db_url` secret, one `with_exec` per step. Returns the per-step
+/// report, or an error carrying the report up to and including the failed step. Shared
+/// by every stage that exercises the module lifecycle on a different engine.
+pub async fn lifecycle(
+    client: &Query,
+    source: Directory,
+    db: Service,
+    db_url: Secret,
+    steps: &[Step],
+    verbosity: Verbosity,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    lifecycle_in(server_env(client, source, db, db_url, opts), steps, verbosity).await
+}
+
+/// `lifecycle` in `env`, a `server_env` container with anything else the steps need.
+pub async fn lifecycle_in(
+    env: Container,
+    steps: &[Step],
+    verbosity: Verbosity,
+) -> eyre::Result<String> {
+    // The steps mutate the database, which Dagger can't see: a per-run nonce keeps a
+    // rerun from replaying cached earlier steps against a fresh database.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let mut container = env
+        .with_env_variable("RUST_LOG", verbosity.rust_log())
+        .with_env_variable("CI_LIFECYCLE_RUN", nonce.to_string());
+
+    let mut report = String::from("=== Integration Test: Module Lifecycle ===\n");
+    for (i, step) in steps.iter().enumerate() {
+        let started = Instant::now();
+        let result = match &step.action {
+            Action::Run(_) | Action::Script(_) => {
+                let next = container.with_exec(step.action.command());
+                match next.stdout().await {
+                    Ok(output) => {
+                        container = next;
+                        let quiet = matches!(verbosity, Verbosity::Quiet);
+                        Ok(if quiet { String::new() } else { output })
+                    }
+                    Err(e) => Err(e.to_string()),
+                }
+            }
+            Action::Verify(checks, module) => {
+                verify(&container, checks, module, verbosity).await
+            }
+        };
+        let took = match verbosity {
+            Verbosity::Debug => format!(" ({:.1}s)", started.elapsed().as_secs_f64()),
+            _ => String::new(),
+        };
+
+        let header = format!("[{}/{}] {}", i + 1, steps.len(), step.label);
+        match result {
+            Ok(output) => report.push_str(&format!("{header}... ok{took}\n{output}")),
+            Err(failure) => {
+                report.push_str(&format!("{header}... FAILED{took}\n{failure}\n"));
+                return Err(eyre::eyre!(report));
+            }
+        }
+    }
+
+    report.push_str("\n=== Integration Test Complete ===\n");
+    Ok(report)
+}
+
+/// Run each check's query against `$DATABASE_URL` and compare the scalar result with
+/// its expectation. The URL is expanded by the shell so it never appears in the command.
+async fn verify(
+    container: &Container,
+    checks: &[Check],
+    module: &str,
+    verbosity: Verbosity,
+) -> Result<String, String> {
+    let debug = matches!(verbosity, Verbosity::Debug);
+    let query = |sql: &str, flags: &str| {
+        container
+            .with_exec(vec!["sh", "-c", PSQL, flags, sql])
+            .stdout()
+    };
+
+    let mut output = String::new();
+    for check in checks {
+        if debug {
+            output.push_str(&format!("    sql> {}\n", check.sql));
+        }
+        let value = query(&check.sql, "-At").await.map_err(|e| format!("{output}{e}"))?;
+        let value = value.trim();
+        output.push_str(&format!("{}: {value}\n", check.label));
+        if !check.expect.holds(value) {
+            return Err(format!(
+                "{output}FAIL: expected {} to be {}, got '{value}'",
+                check.label,
+                check.expect.describe()
+            ));
+        }
+    }
+    if debug {
+        let dump = format!(
+            "SELECT id, module, name, model, res_id FROM ir_model_data \
+             WHERE module = '{module}' ORDER BY id"
+        );
+        output.push_str(&query(&dump, "-q").await.unwrap_or_else(|e| e.to_string()));
+    }
+    Ok(output)
+}
+
+/// `rust_base` with `erp-server` built in release mode at `./target/release/erp-server`,
+/// then `db` bound as host `db` and the `db_url` secret as `DATABASE_URL`. Building
+/// before binding keeps one cached build for every database it runs against.
+pub fn server_env(
+    client: &Query,
+    source: Directory,
+    db: Service,
+    db_url: Secret,
+    opts: &BaseOpts,
+) -> Container {
+    containers::rust_base(client, source, opts)
         .with_exec(vec![
             "cargo", "build", "--release", "--package", "erp_server",
         ])
-        .with_exec(vec!["bash", "-c", test_script])
-        .stdout()
-        .await?;
+        .with_service_binding("db", db)
+        .with_secret_variable("DATABASE_URL", db_url)
+        .with_env_variable("RUST_LOG", "info")
+}
+
+/// Port `erp-server serve` listens on inside the service container.
+pub const SERVER_PORT: isize = 9089;
+
+/// Startup script for a served `erp-server` built at `binary`: migrates, seeds and
+/// installs `base` plus `modules`, then execs `serve`.
+pub fn server_script(binary: &str, modules: &[&str]) -> String {
+    let mut script = format!(
+        "set -e\n\
+         {binary} migrate\n\
+         {binary} seed\n\
+         {binary} module install base\n",
+    );
+    for module in modules {
+        script.push_str(&format!("{binary} module install {module}\n"));
+    }
+    script.push_str(&format!("exec {binary} serve\n"));
+    script
+}
+
+/// `erp-server` as a service on `SERVER_PORT`, backed by `db`; see `server_script`.
+pub fn server_service(
+    client: &Query,
+    source: Directory,
+    db: Service,
+    modules: &[&str],
+    opts: &BaseOpts,
+) -> Service {
+    let script = server_script("./target/release/erp-server", modules);
 
-    Ok(format!("[integration] {output}"))
+    server_env(client, source, db, containers::pg_url(client), opts)
+        .with_exposed_port(SERVER_PORT)
+        .with_default_args(vec!["bash".to_string(), "-c".to_string(), script])
+        .as_service()
 }
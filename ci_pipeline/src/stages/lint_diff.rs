@@ -0,0 +1,121 @@
+use std::collections::BTreeSet;
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::clippy_json::CargoMessage;
+
+/// A warning with its primary location. Ordering and equality ignore the line, so
+/// unrelated edits that shift code around do not turn old warnings into new ones.
+#[derive(Clone, Debug)]
+struct Warning {
+    file: String,
+    lint: String,
+    message: String,
+    line: usize,
+}
+
+impl Warning {
+    fn key(&self) -> (&str, &str, &str) {
+        (&self.file, &self.lint, &self.message)
+    }
+}
+
+impl PartialEq for Warning {
+    fn eq(&self, other: &Self) -> bool {
+        self.key() == other.key()
+    }
+}
+
+impl Eq for Warning {}
+
+impl PartialOrd for Warning {
+    fn partial_cmp(&self, other: &Self) -> Option<std::cmp::Ordering> {
+        Some(self.cmp(other))
+    }
+}
+
+impl Ord for Warning {
+    fn cmp(&self, other: &Self) -> std::cmp::Ordering {
+        self.key().cmp(&other.key())
+    }
+}
+
+/// Run clippy on `source` and `base_source` and fail only on warnings that `source`
+/// introduces, keyed by (file, lint, message). Pre-existing warnings are counted but
+/// not reported, so a new lint can be adopted without first clearing the backlog.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    base_source: Directory,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let (current, baseline) = futures::try_join!(
+        warnings(client, source, "/app/target", opts),
+        warnings(client, base_source, "/app/target/lint-diff-base", opts),
+    )?;
+
+    let new: Vec<&Warning> = current.difference(&baseline).collect();
+    let fixed = baseline.difference(&current).count();
+
+    let mut report = String::new();
+    for w in &new {
+        report.push_str(&format!("  {}:{} [{}] {}\n", w.file, w.line, w.lint, w.message));
+    }
+
+    let summary = format!(
+        "{} new, {fixed} fixed, {} pre-existing",
+        new.len(),
+        current.len() - new.len()
+    );
+    if !new.is_empty() {
+        return Err(eyre::eyre!("[lint-diff] New clippy warnings ({summary})\n{report}"));
+    }
+
+    Ok(format!("[lint-diff] No new clippy warnings ({summary})."))
+}
+
+/// Collect the clippy warnings for one tree. The base tree builds in its own target dir
+/// so the two runs don't invalidate each other's incremental state.
+async fn warnings(
+    client: &Query,
+    source: Directory,
+    target_dir: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<BTreeSet<Warning>> {
+    let output = containers::rust_base(client, source, opts)
+        .with_env_variable("CARGO_TARGET_DIR", target_dir)
+        .with_exec(vec![
+            "cargo", "clippy", "--workspace", "--lib", "--message-format=json",
+            "--", "-W", "clippy::all",
+        ])
+        .stdout()
+        .await?;
+
+    let mut warnings = BTreeSet::new();
+    for line in output.lines() {
+        let Ok(msg) = serde_json::from_str::<CargoMessage>(line) else {
+            continue;
+        };
+        let Some(diag) = msg.message.filter(|_| msg.reason == "compiler-message") else {
+            continue;
+        };
+        if diag.level != "warning" {
+            continue;
+        }
+        let Some(span) = diag.spans.iter().find(|s| s.is_primary) else {
+            continue;
+        };
+        warnings.insert(Warning {
+            file: span.file_name.clone(),
+            lint: diag.code.map(|c| c.code).unwrap_or_else(|| "rustc".into()),
+            message: diag.message,
+            line: span.line_start,
+        });
+    }
+
+    Ok(warnings)
+}
@@ -0,0 +1,103 @@
+use std::time::Instant;
+
+use dagger_sdk::{Container, Directory, Query};
+use futures::future::join_all;
+
+use crate::containers::{self, BaseOpts};
+use crate::metadata;
+
+/// Per-crate clippy script: prints one `CRATE_RESULT <name> <ok|fail>` marker per crate
+/// and always exits 0 so every crate in the bucket is linted.
+const BUCKET_SCRIPT: &str = r#"
+for entry in $CRATES; do
+    name="${entry%%:*}"
+    scope="${entry##*:}"
+    echo "--- clippy -p $name ($scope) ---"
+    if cargo clippy -p "$name" "--$scope" -- -D clippy::correctness -W clippy::all 2>&1; then
+        echo "CRATE_RESULT $name ok"
+    else
+        echo "CRATE_RESULT $name fail"
+    fi
+done
+"#;
+
+/// Run clippy crate-by-crate across `workers` parallel containers.
+///
+/// Each worker gets its own target dir inside the shared `cargo-target` cache, so workers
+/// never block on cargo's build-dir lock, at the cost of compiling shared dependencies once
+/// per worker. This only beats `lint` (one `--workspace` invocation) on an engine with cores
+/// to spare beyond what a single cargo build saturates, and mostly on a warm cache; the wall
+/// time is printed so the two can be compared on a given engine.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    workers: usize,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let base = containers::rust_base(client, source.clone(), opts);
+    containers::ensure_free_disk(&base, opts).await?;
+
+    let workspace = metadata::load(client, source, opts).await?;
+    let crates: Vec<String> = workspace
+        .members()
+        .map(|pkg| format!("{}:{}", pkg.name, if pkg.has_lib() { "lib" } else { "bins" }))
+        .collect();
+
+    let mut buckets: Vec<Vec<String>> = vec![Vec::new(); workers.clamp(1, crates.len().max(1))];
+    for (i, krate) in crates.into_iter().enumerate() {
+        let slot = i % buckets.len();
+        buckets[slot].push(krate);
+    }
+
+    let started = Instant::now();
+    let outputs = join_all(
+        buckets
+            .iter()
+            .enumerate()
+            .map(|(worker, bucket)| lint_bucket(&base, worker, bucket)),
+    )
+    .await;
+    let elapsed = started.elapsed();
+
+    let mut report = String::new();
+    let mut failed = Vec::new();
+    let mut linted = 0;
+    for output in outputs {
+        let output = output?;
+        for line in output.lines() {
+            if let Some(result) = line.strip_prefix("CRATE_RESULT ") {
+                linted += 1;
+                if let Some(name) = result.strip_suffix(" fail") {
+                    failed.push(name.to_string());
+                }
+            }
+        }
+        report.push_str(&output);
+    }
+
+    let summary = format!(
+        "{linted} crate(s) across {} worker(s) in {:.1}s",
+        buckets.len(),
+        elapsed.as_secs_f64()
+    );
+    if !failed.is_empty() {
+        return Err(eyre::eyre!(
+            "[lint-parallel] Clippy failed for: {} ({summary})\n{report}",
+            failed.join(", ")
+        ));
+    }
+
+    Ok(format!("[lint-parallel] Clippy passed, {summary}.\n{report}"))
+}
+
+/// Lint one bucket of crates sequentially in its own target dir.
+async fn lint_bucket(base: &Container, worker: usize, crates: &[String]) -> eyre::Result<String> {
+    let output = base
+        .with_env_variable("CARGO_TARGET_DIR", format!("/app/target/lint-worker-{worker}"))
+        .with_env_variable("CRATES", crates.join(" "))
+        .with_exec(vec!["bash", "-c", BUCKET_SCRIPT])
+        .stdout()
+        .await?;
+
+    Ok(output)
+}
@@ -0,0 +1,119 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use clap::Args;
+use dagger_sdk::{Directory, File, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+const K6_IMAGE: &str = "grafana/k6:0.55.0";
+
+/// Polls `/health` for up to two minutes, so the scenario starts against a ready server.
+/// An exception in the default function only fails the iteration, so k6 would still exit
+/// 0; aborting the test makes it exit non-zero.
+const WAIT_JS: &str = r#"
+import http from "k6/http";
+import exec from "k6/execution";
+import { sleep } from "k6";
+
+export default function () {
+    for (let i = 0; i < 120; i++) {
+        if (http.get(`${__ENV.BASE_URL}/health`).status === 200) return;
+        sleep(1);
+    }
+    exec.test.abort("server not ready after 120s");
+}
+"#;
+
+/// Default scenario: every VU requests each of `PATHS` in turn. The thresholds make k6
+/// exit non-zero when p95 latency or the error rate exceeds its limit.
+const SCENARIO_JS: &str = r#"
+import http from "k6/http";
+
+const paths = __ENV.PATHS.split(",");
+
+export const options = {
+    thresholds: {
+        http_req_duration: [`p(95)<${__ENV.P95_MS}`],
+        http_req_failed: [`rate<${__ENV.MAX_ERROR_RATE}`],
+    },
+};
+
+export default function () {
+    for (const path of paths) {
+        http.get(`${__ENV.BASE_URL}${path}`);
+    }
+}
+"#;
+
+/// Load shape and the limits that fail the run.
+#[derive(Args, Clone, Debug)]
+pub struct LoadOpts {
+    /// Concurrent virtual users
+    #[arg(long, default_value_t = 20)]
+    pub vus: u32,
+    /// Run length in k6 syntax (e.g. 30s, 2m)
+    #[arg(long, default_value = "30s")]
+    pub duration: String,
+    /// Fail when the 95th percentile request duration exceeds this many milliseconds
+    #[arg(long, default_value_t = 500)]
+    pub p95_ms: u32,
+    /// Fail when the share of failed requests exceeds this rate (0.0 - 1.0)
+    #[arg(long, default_value_t = 0.01)]
+    pub max_error_rate: f64,
+}
+
+/// Start `erp-server` and run a k6 scenario against it: `script` when given, otherwise
+/// the built-in one requesting `paths`. Both get `BASE_URL`, `PATHS`, `P95_MS` and
+/// `MAX_ERROR_RATE` in `__ENV`; a custom script must declare its own thresholds.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    script: Option<File>,
+    paths: &[String],
+    load: &LoadOpts,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    let server = integration::server_service(client, source, pg, &[], opts);
+
+    let k6 = client
+        .container()
+        .from(K6_IMAGE)
+        .with_service_binding("erp", server)
+        .with_env_variable("BASE_URL", format!("http://erp:{}", integration::SERVER_PORT))
+        .with_env_variable("PATHS", paths.join(","))
+        .with_env_variable("P95_MS", load.p95_ms.to_string())
+        .with_env_variable("MAX_ERROR_RATE", load.max_error_rate.to_string())
+        .with_new_file("/ci/wait.js", WAIT_JS);
+    let k6 = match script {
+        Some(file) => k6.with_file("/ci/scenario.js", file),
+        None => k6.with_new_file("/ci/scenario.js", SCENARIO_JS),
+    };
+
+    // Latency depends on the engine's load at the time, so never reuse a cached result.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let output = k6
+        .with_env_variable("CI_LOAD_RUN", nonce.to_string())
+        .with_exec(vec!["k6", "run", "--quiet", "--no-summary", "/ci/wait.js"])
+        .with_exec(vec![
+            "k6".to_string(),
+            "run".to_string(),
+            "--vus".to_string(),
+            load.vus.to_string(),
+            "--duration".to_string(),
+            load.duration.clone(),
+            "/ci/scenario.js".to_string(),
+        ])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[load-test] Thresholds exceeded or k6 failed:\n{e}"))?;
+
+    Ok(format!(
+        "[load-test] {} VU(s) for {}: p95 < {}ms, error rate < {}.\n{output}",
+        load.vus, load.duration, load.p95_ms, load.max_error_rate
+    ))
+}
@@ -0,0 +1,84 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+const PROFILE_SCRIPT: &str = r#"
+set -euo pipefail
+
+BINARY="./target/release/erp-server"
+BASE_URL="http://localhost:$SERVER_PORT"
+
+$BINARY migrate > /dev/null 2>&1
+$BINARY seed > /dev/null 2>&1
+$BINARY module install base > /dev/null 2>&1
+
+$BINARY serve > /tmp/server.log 2>&1 &
+PID=$!
+for i in $(seq 1 60); do curl -sf "$BASE_URL/health" > /dev/null && break; sleep 1; done
+
+rss() { awk '/^VmRSS:/ { print $2 }' "/proc/$PID/status"; }
+
+echo "RSS 0 $(rss)"
+for i in $(seq 1 "$ITERATIONS"); do
+    for path in $SMOKE_PATHS; do
+        curl -s -o /dev/null "$BASE_URL$path" || true
+    done
+    echo "RSS $i $(rss)"
+done
+
+kill "$PID"
+"#;
+
+/// Start `erp-server`, run the smoke requests `iterations` times, and sample the
+/// server's RSS after every round. Fails when RSS grows by more than `max_growth_mb`
+/// between the first and last sample, which points at a leak.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    iterations: u32,
+    smoke_paths: &[String],
+    max_growth_mb: f64,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    let output = integration::server_env(client, source, pg, containers::pg_url(client), opts)
+        .with_env_variable("SERVER_PORT", integration::SERVER_PORT.to_string())
+        .with_env_variable("ITERATIONS", iterations.to_string())
+        .with_env_variable("SMOKE_PATHS", smoke_paths.join(" "))
+        .with_exec(vec!["bash", "-c", PROFILE_SCRIPT])
+        .stdout()
+        .await?;
+
+    let samples_kb: Vec<u64> = output
+        .lines()
+        .filter_map(|line| line.strip_prefix("RSS "))
+        .filter_map(|rest| rest.split_whitespace().nth(1)?.parse().ok())
+        .collect();
+    let (Some(first), Some(last)) = (samples_kb.first(), samples_kb.last()) else {
+        return Err(eyre::eyre!("[memory-profile] No RSS samples collected.\n{output}"));
+    };
+    let peak = samples_kb.iter().max().copied().unwrap_or_default();
+
+    let mb = |kb: u64| kb as f64 / 1024.0;
+    let growth = mb(*last) - mb(*first);
+    let summary = format!(
+        "{} samples over {iterations} iterations: start {:.1}MB, end {:.1}MB, \
+         peak {:.1}MB, growth {growth:+.1}MB",
+        samples_kb.len(),
+        mb(*first),
+        mb(*last),
+        mb(peak)
+    );
+
+    if growth > max_growth_mb {
+        return Err(eyre::eyre!(
+            "[memory-profile] RSS grew more than {max_growth_mb}MB (possible leak): {summary}"
+        ));
+    }
+
+    Ok(format!("[memory-profile] {summary}"))
+}
@@ -0,0 +1,74 @@
+use dagger_sdk::{Container, Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::rollback::SNAPSHOT_FN;
+
+const TEST_SCRIPT: &str = r#"
+set -euo pipefail
+
+echo "=== Migration Test: up/down reversibility ==="
+
+echo "[1/3] Applying all migrations..."
+diesel migration run --migration-dir "$MIGRATIONS_DIR" 2>&1
+snapshot > /tmp/schema-before.txt
+echo "$(wc -l < /tmp/schema-before.txt) schema entries"
+
+echo "[2/3] Redoing the last $REDO_COUNT migration(s) (down + up)..."
+if ! diesel migration redo --migration-dir "$MIGRATIONS_DIR" --number "$REDO_COUNT" 2>&1; then
+    echo "FAIL: a down or re-applied up migration errored"
+    exit 1
+fi
+
+echo "[3/3] Checking for schema drift..."
+snapshot > /tmp/schema-after.txt
+if ! diff -u /tmp/schema-before.txt /tmp/schema-after.txt; then
+    echo "FAIL: schema differs after down/up redo"
+    exit 1
+fi
+echo "Schema identical after redo."
+
+echo ""
+echo "=== Migration Test Complete ==="
+"#;
+
+/// Source tree with `diesel_cli` installed (on the cached toolchain layer) and a fresh
+/// PostgreSQL bound as `db` at `$DATABASE_URL`, ready for connections.
+pub async fn diesel_env(
+    client: &Query,
+    source: Directory,
+    opts: &BaseOpts,
+) -> eyre::Result<Container> {
+    let toolchain = containers::rust_toolchain(client, opts).with_exec(containers::retried(
+        &[
+            "cargo", "install", "diesel_cli",
+            "--no-default-features", "--features", "postgres", "--locked",
+        ],
+        opts,
+    ));
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    Ok(containers::with_source(toolchain, source)
+        .with_service_binding("db", pg)
+        .with_secret_variable("DATABASE_URL", containers::pg_url(client)))
+}
+
+/// Apply every Diesel migration, then `diesel migration redo` the last `redo_count`
+/// against a fresh PostgreSQL, failing if a down migration errors or the schema after
+/// the down/up cycle differs from the schema before it.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    redo_count: u32,
+    migrations_dir: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let output = diesel_env(client, source, opts)
+        .await?
+        .with_env_variable("MIGRATIONS_DIR", migrations_dir)
+        .with_env_variable("REDO_COUNT", redo_count.to_string())
+        .with_exec(vec!["bash", "-c", &format!("{SNAPSHOT_FN}{TEST_SCRIPT}")])
+        .stdout()
+        .await?;
+
+    Ok(format!("[migration] {output}"))
+}
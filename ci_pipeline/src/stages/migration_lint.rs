@@ -0,0 +1,40 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Lint every up migration under `migrations_dir` with squawk, failing on locking
+/// hazards such as `NOT NULL` columns added without a default or indexes created
+/// without `CONCURRENTLY`. Down migrations only revert, so they are not linted.
+/// `exclude` lists squawk rule names to skip.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    migrations_dir: &str,
+    exclude: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let mut files = source.glob(format!("{migrations_dir}/**/up.sql")).await?;
+    if files.is_empty() {
+        return Err(eyre::eyre!("[migration-lint] No up.sql files under {migrations_dir}"));
+    }
+    files.sort();
+
+    let mut cmd = vec!["squawk".to_string()];
+    if !exclude.is_empty() {
+        cmd.push(format!("--exclude={}", exclude.join(",")));
+    }
+    cmd.extend(files.iter().cloned());
+
+    let output = client
+        .container()
+        .from("node:22-slim")
+        .with_exec(containers::retried(&["npm", "install", "-g", "squawk-cli@1"], opts))
+        .with_directory("/app", source)
+        .with_workdir("/app")
+        .with_exec(cmd)
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[migration-lint] squawk found migration hazards:\n{e}"))?;
+
+    Ok(format!("[migration-lint] {} migration(s) passed squawk.\n{output}", files.len()))
+}
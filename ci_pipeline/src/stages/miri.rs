@@ -0,0 +1,46 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts, RustChannel};
+
+/// Run the unit tests (`--lib`) of `packages` under Miri on the nightly toolchain (in its
+/// own target subdir), failing on any undefined behavior it detects. Miri interprets
+/// every test, so it only runs on the crates asked for; `miri_flags` is passed through as
+/// `MIRIFLAGS`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    packages: &[String],
+    miri_flags: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    if packages.is_empty() {
+        return Err(eyre::eyre!("[miri] No packages selected"));
+    }
+    let opts = BaseOpts {
+        rust_channel: RustChannel::Nightly,
+        // A prebuilt image carries its own toolchain, not nightly.
+        base_image: None,
+        target_subdir: Some("miri".to_string()),
+        ..opts.clone()
+    };
+
+    let toolchain = containers::rust_toolchain(client, &opts)
+        .with_exec(containers::retried(
+            &["rustup", "component", "add", "--toolchain", "nightly", "miri", "rust-src"],
+            &opts,
+        ))
+        .with_exec(containers::retried(&["cargo", "miri", "setup"], &opts));
+
+    let mut cmd = vec!["cargo", "miri", "test", "--lib"];
+    for package in packages {
+        cmd.extend(["-p", package.as_str()]);
+    }
+    let output = containers::with_source(toolchain, source)
+        .with_env_variable("MIRIFLAGS", miri_flags)
+        .with_exec(cmd)
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[miri] Undefined behavior or test failure:\n{e}"))?;
+
+    Ok(format!("[miri] No undefined behavior in {}.\n{output}", packages.join(", ")))
+}
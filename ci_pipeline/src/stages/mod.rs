@@ -1,9 +1,71 @@
+pub mod all_modules;
+pub mod api_schema;
+pub mod api_test;
+pub mod audit_fix;
+pub mod backup_restore;
+pub mod bench;
+pub mod build;
+pub mod build_cross;
+pub mod build_script_audit;
+pub mod cache_stats;
+pub mod changed;
 pub mod check;
+pub mod clippy_json;
+pub mod cockroach;
+pub mod compose;
+pub mod coverage;
+pub mod debug_shell;
+pub mod deny;
 pub mod deploy;
+pub mod doc_test;
+pub mod docs;
+pub mod flaky;
 pub mod fmt;
+pub mod frontend;
+pub mod fuzz;
+pub mod helm;
+pub mod idempotency;
+pub mod image_scan;
+pub mod install_order;
 pub mod integration;
 pub mod lint;
+pub mod lint_diff;
+pub mod lint_parallel;
+pub mod load_test;
+pub mod memory_profile;
+pub mod migration;
+pub mod migration_lint;
+pub mod miri;
+pub mod module_graph;
+pub mod module_lifecycle;
 pub mod module_lint;
+pub mod msrv;
+pub mod mutants;
+pub mod next_version;
+pub mod notify;
+pub mod pg_matrix;
+pub mod publish;
+pub mod publish_base;
+pub mod release;
+pub mod report_github;
+pub mod rollback;
+pub mod sanitize;
+pub mod sarif;
+pub mod sbom;
+pub mod scenarios;
+pub mod schema_drift;
+pub mod secret_scan;
 pub mod security;
+pub mod seed_idempotency;
+pub mod sign;
+pub mod sql_test;
 pub mod tailwind;
 pub mod test;
+pub mod test_order;
+pub mod test_sharded;
+pub mod toolchain_matrix;
+pub mod udeps;
+pub mod upgrade;
+pub mod view_render;
+pub mod warm_cache;
+pub mod web_e2e;
@@ -0,0 +1,80 @@
+use std::collections::BTreeMap;
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::manifest::{self, Manifest};
+
+/// Render the module dependency graph as Graphviz DOT: one node per module with a
+/// manifest, built-ins dashed, an edge from each module to each dependency.
+fn dot(manifests: &BTreeMap<String, Manifest>, builtins: &[String]) -> String {
+    let mut dot = String::from("digraph modules {\n    rankdir=LR;\n    node [shape=box];\n");
+    for name in builtins {
+        dot.push_str(&format!("    \"{name}\" [style=dashed];\n"));
+    }
+    for (name, manifest) in manifests {
+        dot.push_str(&format!("    \"{name}\";\n"));
+        for dep in &manifest.module.depends {
+            dot.push_str(&format!("    \"{name}\" -> \"{dep}\";\n"));
+        }
+    }
+    dot.push_str("}\n");
+    dot
+}
+
+/// Parse every module manifest and check the dependency graph: each dependency must be
+/// a module in the tree or one of `builtins` (framework modules without a manifest
+/// here), and the graph must be acyclic. With `output`, `modules.dot` and a rendered
+/// `modules.svg` are exported there, also when the check fails.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    builtins: &[String],
+    output: Option<&str>,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let manifests = manifest::load_all(&source).await?;
+
+    if let Some(output) = output {
+        let graph = client.directory().with_new_file("modules.dot", dot(&manifests, builtins));
+        client
+            .container()
+            .from("alpine:3.21")
+            .with_exec(containers::retried(&["apk", "add", "--no-cache", "graphviz"], opts))
+            .with_directory("/graph", graph)
+            .with_workdir("/graph")
+            .with_exec(vec!["dot", "-Tsvg", "modules.dot", "-o", "modules.svg"])
+            .directory("/graph")
+            .export(output)
+            .await?;
+    }
+
+    let mut missing = Vec::new();
+    for (name, manifest) in &manifests {
+        for dep in &manifest.module.depends {
+            if !manifests.contains_key(dep) && !builtins.contains(dep) {
+                missing.push(format!("{name} depends on unknown module '{dep}'"));
+            }
+        }
+    }
+    if !missing.is_empty() {
+        return Err(eyre::eyre!(
+            "[module-graph] Unknown dependencies:\n  - {}",
+            missing.join("\n  - ")
+        ));
+    }
+
+    let order = manifest::install_order_all(&manifests)
+        .map_err(|e| eyre::eyre!("[module-graph] {e}"))?;
+    let exported = match output {
+        Some(output) => format!(" Graph exported to {output}."),
+        None => String::new(),
+    };
+
+    Ok(format!(
+        "[module-graph] {} module(s), acyclic, no unknown dependencies.{exported}\n\
+         Install order: {}",
+        manifests.len(),
+        order.join(" -> ")
+    ))
+}
@@ -0,0 +1,38 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration::{self, Verbosity};
+
+/// Identifier check for names interpolated into SQL and commands.
+pub fn valid_identifier(name: &str) -> bool {
+    !name.is_empty() && name.chars().all(|c| c.is_ascii_alphanumeric() || c == '_')
+}
+
+/// Run install → verify → upgrade → verify → uninstall → verify-cleanup for `module`
+/// against a fresh PostgreSQL. `tables` are the module's tables, expected to exist
+/// while it is installed and to be dropped by the uninstall.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    module: &str,
+    tables: &[String],
+    verbosity: Verbosity,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    for name in std::iter::once(module).chain(tables.iter().map(String::as_str)) {
+        if !valid_identifier(name) {
+            return Err(eyre::eyre!("invalid module or table name '{name}'"));
+        }
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    let steps = integration::lifecycle_steps(module, tables, true);
+    let db_url = containers::pg_url(client);
+    let output =
+        integration::lifecycle(client, source, pg, db_url, &steps, verbosity, opts).await?;
+
+    Ok(format!("[module-lifecycle] {output}"))
+}
@@ -1,98 +1,451 @@
-use dagger_sdk::{Directory, Query};
-
-use crate::containers;
-
-/// Validate module manifests, XML data files, and code patterns.
-pub async fn run(client: &Query, source: Directory) -> eyre::Result<String> {
-    let script = r#"
-set -euo pipefail
-
-ERRORS=0
-WARNINGS=0
-
-echo "=== Module Lint ==="
-
-# 1. Manifest validation
-echo "[1/5] Checking module manifests..."
-for manifest in modules/*/manifest.toml; do
-    module_dir=$(dirname "$manifest")
-
-    if ! grep -q '^\[module\]' "$manifest"; then
-        echo "ERROR: $manifest missing [module] section"
-        ERRORS=$((ERRORS + 1))
-    fi
-    if ! grep -q 'name\s*=' "$manifest"; then
-        echo "ERROR: $manifest missing 'name' key"
-        ERRORS=$((ERRORS + 1))
-    fi
-
-    for datafile in $(grep -oP '(?<=\")[^\"]+\.xml(?=\")' "$manifest" 2>/dev/null || true); do
-        if [ ! -f "$module_dir/$datafile" ]; then
-            echo "ERROR: $manifest declares '$datafile' but file not found"
-            ERRORS=$((ERRORS + 1))
-        fi
-    done
-    for datafile in $(grep -oP '(?<=\")[^\"]+\.csv(?=\")' "$manifest" 2>/dev/null || true); do
-        if [ ! -f "$module_dir/$datafile" ]; then
-            echo "ERROR: $manifest declares '$datafile' but file not found"
-            ERRORS=$((ERRORS + 1))
-        fi
-    done
-done
-
-# 2. XML validation
-echo "[2/5] Checking XML data files..."
-for xmlfile in modules/*/data/*.xml modules/*/views/*.xml modules/*/security/*.xml; do
-    [ -f "$xmlfile" ] || continue
-    if ! xmllint --noout "$xmlfile" 2>/dev/null; then
-        echo "ERROR: $xmlfile is not well-formed XML"
-        ERRORS=$((ERRORS + 1))
-    fi
-done
-
-# 3. Duplicate record IDs
-echo "[3/5] Checking for duplicate record IDs..."
-for module_dir in modules/*/; do
-    [ -d "$module_dir" ] || continue
-    module_name=$(basename "$module_dir")
-    ids=$(grep -roh 'id="[^"]*"' "$module_dir" 2>/dev/null | sort | uniq -d)
-    if [ -n "$ids" ]; then
-        echo "WARNING: Duplicate record IDs in $module_name: $ids"
-        WARNINGS=$((WARNINGS + 1))
-    fi
-done
-
-# 4. Unsafe SQL patterns
-echo "[4/5] Checking for unsafe SQL patterns..."
-for rsfile in $(find modules/ erp_core/src/ -name '*.rs' 2>/dev/null); do
-    [ -f "$rsfile" ] || continue
-    if grep -Pn 'format!\s*\(\s*"[^"]*(?:SELECT|INSERT|UPDATE|DELETE)' "$rsfile" 2>/dev/null | grep -v 'bind\|\.execute\|sql_query' | head -3; then
-        echo "WARNING: Possible unparameterized SQL in $rsfile"
-        WARNINGS=$((WARNINGS + 1))
-    fi
-done
-
-# 5. Panic patterns
-echo "[5/5] Checking for panic patterns..."
-if find modules/ erp_core/src/ -name '*.rs' -exec grep -ln 'panic!\|todo!\|unimplemented!' {} \; 2>/dev/null | head -5 | grep -q .; then
-    echo "WARNING: Found panic!/todo!/unimplemented! macros in source code"
-    WARNINGS=$((WARNINGS + 1))
-fi
-
-echo ""
-echo "=== Module Lint Complete ==="
-echo "Errors: $ERRORS, Warnings: $WARNINGS"
-
-if [ $ERRORS -gt 0 ]; then
-    exit 1
-fi
+use std::collections::{BTreeMap, BTreeSet};
+
+use dagger_sdk::{Directory, File, Query};
+use quick_xml::events::Event;
+use quick_xml::Reader;
+use serde::Serialize;
+
+use crate::containers::{self, BaseOpts};
+
+/// Keys every manifest's `[module]` table must define as strings.
+const REQUIRED_KEYS: &[&str] = &["name"];
+
+/// Rust sources scanned by the code-pattern rules.
+const CODE_PATTERNS: &[&str] = &["modules/**/*.rs", "erp_core/src/**/*.rs"];
+
+/// SQL verbs that mark a `format!` string as a query.
+const SQL_VERBS: &[&str] = &["SELECT", "INSERT", "UPDATE", "DELETE"];
+
+/// Calls showing a formatted query is still bound or executed through Diesel.
+const SQL_SAFE_MARKERS: &[&str] = &["bind", ".execute", "sql_query"];
+
+/// Macros that abort at runtime.
+const PANIC_MACROS: &[&str] = &["panic!", "todo!", "unimplemented!"];
+
+/// Centrix view schema, used for module XML when no schema is passed and the
+/// workspace ships one.
+const DEFAULT_SCHEMA: &str = "erp_core/schema/view.rng";
+
+/// Validates every file in `$@` against `$SCHEMA` (RelaxNG, or XSD for `.xsd`), printing
+/// xmllint's `file:line: message` diagnostics; always exits 0.
+const SCHEMA_SCRIPT: &str = r#"
+case "$SCHEMA" in
+    *.xsd) mode=--schema ;;
+    *) mode=--relaxng ;;
+esac
+xmllint --noout "$mode" "$SCHEMA" "$@" 2>&1 || true
 "#;
 
-    let output = containers::rust_base(client, source)
-        .with_exec(vec!["apt-get", "install", "-y", "libxml2-utils"])
-        .with_exec(vec!["bash", "-c", script])
+/// Record ID -> every (file, line) defining it, within one module.
+type RecordSites<'a> = BTreeMap<String, Vec<(&'a str, usize)>>;
+
+#[derive(Clone, Copy, Debug, PartialEq, Eq, Serialize)]
+#[serde(rename_all = "lowercase")]
+pub enum Severity {
+    Error,
+    Warning,
+}
+
+/// One rule violation. `line` is 1-based and absent when the rule applies to the file
+/// as a whole.
+#[derive(Debug, Serialize)]
+pub struct Finding {
+    pub rule: &'static str,
+    pub severity: Severity,
+    pub file: String,
+    pub line: Option<usize>,
+    pub message: String,
+}
+
+/// Every finding of a run, with per-severity counts.
+#[derive(Debug, Default, Serialize)]
+pub struct LintReport {
+    pub errors: usize,
+    pub warnings: usize,
+    pub findings: Vec<Finding>,
+}
+
+impl LintReport {
+    fn push(
+        &mut self,
+        rule: &'static str,
+        severity: Severity,
+        file: &str,
+        line: Option<usize>,
+        message: String,
+    ) {
+        match severity {
+            Severity::Error => self.errors += 1,
+            Severity::Warning => self.warnings += 1,
+        }
+        self.findings.push(Finding { rule, severity, file: file.to_string(), line, message });
+    }
+
+    pub fn to_json(&self) -> eyre::Result<String> {
+        Ok(serde_json::to_string_pretty(self)?)
+    }
+
+    /// One `SEVERITY [rule] file:line: message` line per finding plus the counts.
+    pub fn to_text(&self) -> String {
+        let mut out = String::from("=== Module Lint ===\n");
+        for f in &self.findings {
+            let severity = match f.severity {
+                Severity::Error => "ERROR",
+                Severity::Warning => "WARNING",
+            };
+            let location = match f.line {
+                Some(line) => format!("{}:{line}", f.file),
+                None => f.file.clone(),
+            };
+            out.push_str(&format!("{severity} [{}] {location}: {}\n", f.rule, f.message));
+        }
+        out.push_str(&format!(
+            "\n=== Module Lint Complete ===\nErrors: {}, Warnings: {}\n",
+            self.errors, self.warnings
+        ));
+        out
+    }
+}
+
+/// Validate module manifests, XML data files, duplicate record IDs, and code patterns.
+/// Manifests and XML are parsed rather than grepped, so multi-line TOML arrays and
+/// commented-out records are handled correctly. Well-formed XML is also validated
+/// against `schema`, or the workspace's `erp_core/schema/view.rng` when present, so
+/// unknown tags and attributes are reported too.
+pub async fn lint(
+    client: &Query,
+    source: &Directory,
+    schema: Option<File>,
+    opts: &BaseOpts,
+) -> eyre::Result<LintReport> {
+    let mut files = BTreeSet::new();
+    for pattern in ["modules/**/*.xml", "modules/**/*.csv"] {
+        files.extend(source.glob(pattern).await?);
+    }
+
+    let mut report = LintReport::default();
+    for path in source.glob("modules/*/manifest.toml").await? {
+        let text = source.file(path.as_str()).contents().await?;
+        check_manifest(&path, &text, &files, &mut report);
+    }
+
+    let mut xml_files = Vec::new();
+    for path in files.iter().filter(|p| p.ends_with(".xml")) {
+        xml_files.push((path.clone(), source.file(path.as_str()).contents().await?));
+    }
+    let well_formed = check_records(&xml_files, &mut report);
+
+    let schema = match schema {
+        Some(schema) => Some(schema),
+        None if !source.glob(DEFAULT_SCHEMA).await?.is_empty() => {
+            Some(source.file(DEFAULT_SCHEMA))
+        }
+        None => None,
+    };
+    if let Some(schema) = schema.filter(|_| !well_formed.is_empty()) {
+        check_schema(client, source, schema, &well_formed, &mut report, opts).await?;
+    }
+
+    for pattern in CODE_PATTERNS {
+        for path in source.glob(*pattern).await? {
+            let code = source.file(path.as_str()).contents().await?;
+            check_code(&path, &code, &mut report);
+        }
+    }
+
+    Ok(report)
+}
+
+/// Run `lint` and report the findings as text, failing when any is an error.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    schema: Option<File>,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let report = lint(client, &source, schema, opts).await?;
+    if report.errors > 0 {
+        return Err(eyre::eyre!("[module-lint] {}", report.to_text()));
+    }
+    Ok(format!("[module-lint] {}", report.to_text()))
+}
+
+/// Validate `files` against `schema` with xmllint, reporting each diagnostic as an
+/// `xml-schema` error. Files that are not well-formed are left out; they already have
+/// an `xml-well-formed` finding.
+async fn check_schema(
+    client: &Query,
+    source: &Directory,
+    schema: File,
+    files: &[&str],
+    report: &mut LintReport,
+    opts: &BaseOpts,
+) -> eyre::Result<()> {
+    // The extension decides between RelaxNG and XSD.
+    let schema_path = format!("/ci/{}", schema.name().await?);
+    let mut cmd = vec!["sh", "-c", SCHEMA_SCRIPT, "xmllint"];
+    cmd.extend(files);
+
+    let output = client
+        .container()
+        .from("alpine:3.21")
+        .with_exec(containers::retried(&["apk", "add", "--no-cache", "libxml2-utils"], opts))
+        .with_file(schema_path.as_str(), schema)
+        .with_env_variable("SCHEMA", schema_path.as_str())
+        .with_directory("/src", source.clone())
+        .with_workdir("/src")
+        .with_exec(cmd)
         .stdout()
         .await?;
 
-    Ok(format!("[module-lint] {output}"))
+    // Diagnostics look like `modules/m/views.xml:12: element field: Relax-NG validity
+    // error : ...`; the closing `<file> fails to validate` lines add nothing.
+    for line in output.lines() {
+        let mut parts = line.splitn(3, ':');
+        let (Some(file), Some(Ok(number)), Some(message)) =
+            (parts.next(), parts.next().map(str::parse::<usize>), parts.next())
+        else {
+            continue;
+        };
+        report.push("xml-schema", Severity::Error, file, Some(number), message.trim().to_string());
+    }
+    Ok(())
+}
+
+/// 1-based line of the first occurrence of `needle` in `text`.
+fn line_of(text: &str, needle: &str) -> Option<usize> {
+    text.find(needle).map(|offset| line_at(text, offset))
+}
+
+/// 1-based line containing byte `offset` of `text`.
+fn line_at(text: &str, offset: usize) -> usize {
+    text.as_bytes()[..offset.min(text.len())].iter().filter(|b| **b == b'\n').count() + 1
+}
+
+/// Check one manifest: a `[module]` table with every `REQUIRED_KEYS` entry, and every
+/// `.xml`/`.csv` path it declares present under the module directory.
+fn check_manifest(path: &str, text: &str, files: &BTreeSet<String>, report: &mut LintReport) {
+    let manifest: toml::Table = match toml::from_str(text) {
+        Ok(manifest) => manifest,
+        Err(e) => {
+            let line = e.span().map(|span| line_at(text, span.start));
+            let message = format!("not valid TOML: {}", e.message());
+            return report.push("manifest-toml", Severity::Error, path, line, message);
+        }
+    };
+
+    match manifest.get("module").and_then(toml::Value::as_table) {
+        Some(module) => {
+            for key in REQUIRED_KEYS {
+                if !module.get(*key).is_some_and(toml::Value::is_str) {
+                    let line = line_of(text, "[module]");
+                    let message = format!("missing '{key}' key");
+                    report.push("manifest-required-key", Severity::Error, path, line, message);
+                }
+            }
+        }
+        None => report.push(
+            "manifest-module-section",
+            Severity::Error,
+            path,
+            None,
+            "missing [module] section".to_string(),
+        ),
+    }
+
+    let module_dir = path.trim_end_matches("manifest.toml");
+    let mut declared = Vec::new();
+    manifest.values().for_each(|v| data_files(v, &mut declared));
+    for datafile in declared {
+        if !files.contains(&format!("{module_dir}{datafile}")) {
+            report.push(
+                "manifest-data-file",
+                Severity::Error,
+                path,
+                line_of(text, datafile),
+                format!("declares '{datafile}' but file not found"),
+            );
+        }
+    }
+}
+
+/// Every string in `value` that names an `.xml` or `.csv` file, at any depth.
+fn data_files<'a>(value: &'a toml::Value, out: &mut Vec<&'a str>) {
+    match value {
+        toml::Value::String(s) if s.ends_with(".xml") || s.ends_with(".csv") => out.push(s),
+        toml::Value::Array(items) => items.iter().for_each(|v| data_files(v, out)),
+        toml::Value::Table(table) => table.values().for_each(|v| data_files(v, out)),
+        _ => {}
+    }
+}
+
+/// `id` attributes of every element in `xml` with their line; comments are skipped by
+/// the parser.
+fn record_ids(xml: &str) -> eyre::Result<Vec<(String, usize)>> {
+    let mut reader = Reader::from_str(xml);
+    let mut ids = Vec::new();
+    loop {
+        let start = reader.buffer_position() as usize;
+        match reader.read_event()? {
+            Event::Start(e) | Event::Empty(e) => {
+                if let Some(id) = e.try_get_attribute("id")? {
+                    // The event may begin after leading whitespace; count from its `<`.
+                    let offset = xml[start..].find('<').map_or(start, |i| start + i);
+                    ids.push((id.unescape_value()?.into_owned(), line_at(xml, offset)));
+                }
+            }
+            Event::Eof => break,
+            _ => {}
+        }
+    }
+    Ok(ids)
+}
+
+/// Check the `(path, contents)` XML files: each must be well-formed, and a record ID
+/// defined more than once within a module is reported at its first definition. Returns
+/// the paths of the well-formed files.
+fn check_records<'a>(xml_files: &'a [(String, String)], report: &mut LintReport) -> Vec<&'a str> {
+    let mut ids: BTreeMap<&str, RecordSites> = BTreeMap::new();
+    let mut well_formed = Vec::new();
+    for (path, xml) in xml_files {
+        match record_ids(xml) {
+            Ok(found) => {
+                well_formed.push(path.as_str());
+                let module = path.split('/').nth(1).unwrap_or_default();
+                let by_id = ids.entry(module).or_default();
+                for (id, line) in found {
+                    by_id.entry(id).or_default().push((path, line));
+                }
+            }
+            Err(e) => report.push(
+                "xml-well-formed",
+                Severity::Error,
+                path,
+                None,
+                format!("not well-formed XML: {e}"),
+            ),
+        }
+    }
+    for (module, by_id) in &ids {
+        for (id, sites) in by_id.iter().filter(|(_, sites)| sites.len() > 1) {
+            let (file, line) = sites[0];
+            let others: Vec<String> =
+                sites[1..].iter().map(|(f, l)| format!("{f}:{l}")).collect();
+            report.push(
+                "duplicate-record-id",
+                Severity::Warning,
+                file,
+                Some(line),
+                format!("record ID '{id}' in {module} also defined at {}", others.join(", ")),
+            );
+        }
+    }
+    well_formed
+}
+
+/// Line-based code rules: SQL assembled with `format!` and not visibly bound or run
+/// through Diesel (`unsafe-sql`), and macros that panic at runtime (`panic-macro`).
+/// Comment lines are skipped.
+fn check_code(path: &str, code: &str, report: &mut LintReport) {
+    for (i, line) in code.lines().enumerate() {
+        let trimmed = line.trim_start();
+        if trimmed.starts_with("//") {
+            continue;
+        }
+
+        if let Some(args) = trimmed.split_once("format!").map(|(_, rest)| rest) {
+            let literal = args.trim_start().trim_start_matches('(').trim_start();
+            let query = literal.strip_prefix('"').and_then(|s| s.split('"').next());
+            let formatted_sql = query.is_some_and(|q| SQL_VERBS.iter().any(|v| q.contains(v)));
+            if formatted_sql && !SQL_SAFE_MARKERS.iter().any(|m| line.contains(m)) {
+                report.push(
+                    "unsafe-sql",
+                    Severity::Warning,
+                    path,
+                    Some(i + 1),
+                    "possible unparameterized SQL built with format!".to_string(),
+                );
+            }
+        }
+
+        if let Some(found) = PANIC_MACROS.iter().find(|m| trimmed.contains(*m)) {
+            report.push(
+                "panic-macro",
+                Severity::Warning,
+                path,
+                Some(i + 1),
+                format!("{found} in source code"),
+            );
+        }
+    }
+}
+
+#[cfg(test)]
+mod tests {
+    use super::*;
+
+    const MANIFEST: &str = "[module]\nname = \"sales\"\ndata = [\"views.xml\"]\n";
+    const VIEWS: &str = "<data>\n  <record id=\"view_order\" model=\"ir.ui.view\"/>\n</data>\n";
+
+    /// Lint a fixture module tree of `(path, contents)` files with the manifest and
+    /// record checks, as `lint` does for the workspace.
+    fn lint_tree(tree: &[(&str, &str)]) -> LintReport {
+        let files: BTreeSet<String> = tree.iter().map(|(path, _)| path.to_string()).collect();
+        let mut report = LintReport::default();
+        for (path, text) in tree.iter().filter(|(p, _)| p.ends_with("manifest.toml")) {
+            check_manifest(path, text, &files, &mut report);
+        }
+        let xml: Vec<(String, String)> = tree
+            .iter()
+            .filter(|(p, _)| p.ends_with(".xml"))
+            .map(|(p, c)| (p.to_string(), c.to_string()))
+            .collect();
+        check_records(&xml, &mut report);
+        report
+    }
+
+    /// Case name, module tree, and the rules of the expected findings in order.
+    type Case<'a> = (&'a str, &'a [(&'a str, &'a str)], &'a [&'a str]);
+
+    #[test]
+    fn fixture_trees() {
+        let cases: &[Case] = &[
+            (
+                "valid module",
+                &[("modules/sales/manifest.toml", MANIFEST), ("modules/sales/views.xml", VIEWS)],
+                &[],
+            ),
+            (
+                "missing name key",
+                &[
+                    ("modules/sales/manifest.toml", "[module]\ndata = [\"views.xml\"]\n"),
+                    ("modules/sales/views.xml", VIEWS),
+                ],
+                &["manifest-required-key"],
+            ),
+            (
+                "dangling data file",
+                &[("modules/sales/manifest.toml", MANIFEST)],
+                &["manifest-data-file"],
+            ),
+            (
+                "duplicate ID across two XML files",
+                &[
+                    (
+                        "modules/sales/manifest.toml",
+                        "[module]\nname = \"sales\"\ndata = [\"views.xml\", \"menus.xml\"]\n",
+                    ),
+                    ("modules/sales/views.xml", VIEWS),
+                    ("modules/sales/menus.xml", VIEWS),
+                ],
+                &["duplicate-record-id"],
+            ),
+        ];
+
+        for (name, tree, expected) in cases {
+            let report = lint_tree(tree);
+            let rules: Vec<&str> = report.findings.iter().map(|f| f.rule).collect();
+            assert_eq!(&rules, expected, "{name}: {:?}", report.findings);
+        }
+    }
 }
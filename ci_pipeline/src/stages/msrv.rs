@@ -0,0 +1,41 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{BaseOpts, RustChannel};
+use crate::stages::check;
+
+/// The declared MSRV: `rust-version` under `[workspace.package]`, else `[package]`.
+fn rust_version(cargo_toml: &str) -> eyre::Result<String> {
+    let manifest: toml::Table = toml::from_str(cargo_toml)?;
+    let declared = |root: &toml::Table| {
+        root.get("package")?.get("rust-version")?.as_str().map(String::from)
+    };
+    manifest
+        .get("workspace")
+        .and_then(toml::Value::as_table)
+        .and_then(declared)
+        .or_else(|| declared(&manifest))
+        .ok_or_else(|| eyre::eyre!("Cargo.toml declares no rust-version"))
+}
+
+/// Run `cargo check` on exactly the toolchain named by the workspace's `rust-version`,
+/// failing when the declared MSRV no longer compiles.
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let msrv = rust_version(&source.file("Cargo.toml").contents().await?)?;
+
+    let msrv_opts = BaseOpts {
+        rust_version: msrv.clone(),
+        rust_channel: RustChannel::Stable,
+        rust_base_digest: None,
+        // A prebuilt image carries its own toolchain, not the MSRV.
+        base_image: None,
+        target_subdir: Some("msrv".to_string()),
+        ..opts.clone()
+    };
+    msrv_opts.validate()?;
+
+    check::run(client, source, &msrv_opts)
+        .await
+        .map_err(|e| eyre::eyre!("[msrv] Declared MSRV {msrv} no longer compiles.\n{e}"))?;
+
+    Ok(format!("[msrv] Workspace compiles on its declared MSRV {msrv}."))
+}
@@ -0,0 +1,72 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Runs cargo-mutants for at most `$BUDGET` seconds. Missed mutants make it exit 2 and
+/// the budget running out ends it early; both still leave the per-outcome lists in
+/// `/ci/mutants.out`, which are what the stage reports. The status is recorded in
+/// `/ci/status`.
+const MUTANTS_SCRIPT: &str = r#"
+mkdir -p /ci/mutants.out
+touch /ci/mutants.out/caught.txt /ci/mutants.out/missed.txt /ci/mutants.out/timeout.txt \
+    /ci/mutants.out/unviable.txt
+timeout "$BUDGET" cargo mutants --package "$PACKAGE" --output /ci --timeout "$TEST_TIMEOUT" \
+    --no-shuffle 2>&1
+echo $? > /ci/status
+"#;
+
+/// Mutate `package` with cargo-mutants for up to `budget_secs` and report how many
+/// mutants the tests caught and which survived. Survivors are a quality signal, not a
+/// failure; the stage fails only when cargo-mutants can't run, e.g. when the unmutated
+/// tests fail. `test_timeout_secs` bounds each mutant's test run, as a mutant may loop.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    package: &str,
+    budget_secs: u64,
+    test_timeout_secs: u64,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let opts = BaseOpts { target_subdir: Some("mutants".to_string()), ..opts.clone() };
+    let toolchain = containers::rust_toolchain(client, &opts).with_exec(containers::retried(
+        &["cargo", "install", "cargo-mutants", "--locked"],
+        &opts,
+    ));
+    let ran = containers::with_source(toolchain, source)
+        .with_env_variable("PACKAGE", package)
+        .with_env_variable("BUDGET", budget_secs.to_string())
+        .with_env_variable("TEST_TIMEOUT", test_timeout_secs.to_string())
+        .with_exec(vec!["bash", "-c", MUTANTS_SCRIPT]);
+    let log = ran.stdout().await?;
+
+    // 0: all caught, 2: some missed, 3: some timed out, 124: budget exhausted.
+    let status = ran.file("/ci/status").contents().await?;
+    let status = status.trim();
+    if !matches!(status, "0" | "2" | "3" | "124") {
+        return Err(eyre::eyre!("[mutants] cargo mutants failed (exit {status}):\n{log}"));
+    }
+
+    let out = ran.directory("/ci/mutants.out");
+    let mut counts = Vec::new();
+    for outcome in ["caught", "missed", "timeout", "unviable"] {
+        let list = out.file(format!("{outcome}.txt")).contents().await?;
+        counts.push((outcome, list.lines().count(), list));
+    }
+    let tested: usize = counts.iter().map(|(_, n, _)| n).sum();
+    let summary: Vec<String> = counts.iter().map(|(o, n, _)| format!("{n} {o}")).collect();
+    let missed = &counts[1].2;
+    let budget = if status == "124" {
+        format!(" (stopped after the {budget_secs}s budget)")
+    } else {
+        String::new()
+    };
+
+    let mut report = format!(
+        "[mutants] {package}: {tested} mutant(s) tested{budget}: {}.",
+        summary.join(", ")
+    );
+    if !missed.is_empty() {
+        report.push_str(&format!("\nSurviving mutants:\n{missed}"));
+    }
+    Ok(report)
+}
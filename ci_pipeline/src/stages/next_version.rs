@@ -0,0 +1,82 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::git::{self, Commit};
+
+/// Semver component a set of commits calls for, ordered so the largest wins.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, PartialOrd, Ord)]
+enum Bump {
+    None,
+    Patch,
+    Minor,
+    Major,
+}
+
+/// Breaking changes bump major, `feat` minor, `fix` and `perf` patch; other types and
+/// non-conventional subjects don't call for a release.
+fn bump(commits: &[Commit]) -> Bump {
+    commits
+        .iter()
+        .filter_map(Commit::conventional)
+        .map(|c| match c.kind {
+            _ if c.breaking => Bump::Major,
+            "feat" => Bump::Minor,
+            "fix" | "perf" => Bump::Patch,
+            _ => Bump::None,
+        })
+        .max()
+        .unwrap_or(Bump::None)
+}
+
+/// `X.Y.Z` of a tag such as `v1.4.2` or `1.4.2-rc.1`.
+fn parse_tag(tag: &str) -> Option<(u64, u64, u64)> {
+    let core = tag.strip_prefix('v').unwrap_or(tag).split(['-', '+']).next()?;
+    let mut parts = core.split('.').map(str::parse);
+    match (parts.next(), parts.next(), parts.next(), parts.next()) {
+        (Some(Ok(major)), Some(Ok(minor)), Some(Ok(patch)), None) => Some((major, minor, patch)),
+        _ => None,
+    }
+}
+
+/// Next version after the latest tag reachable from `HEAD` (`0.0.0` when there is
+/// none), from the Conventional Commits in `range` (default: the tag to `HEAD`), or
+/// `None` when no commit calls for a release; paired with the number of commits read.
+/// Before 1.0.0 a breaking change bumps the minor version, as semver leaves 0.x unstable.
+pub async fn next(
+    client: &Query,
+    repo: Directory,
+    range: Option<&str>,
+) -> eyre::Result<(Option<String>, usize)> {
+    let tag = git::last_tag(client, repo.clone()).await?;
+    let (major, minor, patch) = match &tag {
+        Some(tag) => parse_tag(tag)
+            .ok_or_else(|| eyre::eyre!("latest tag '{tag}' is not a semver version"))?,
+        None => (0, 0, 0),
+    };
+    let range = match (range, &tag) {
+        (Some(range), _) => range.to_string(),
+        (None, Some(tag)) => format!("{tag}..HEAD"),
+        (None, None) => "HEAD".to_string(),
+    };
+    let commits = git::log(client, repo, &range).await?;
+
+    let next = match bump(&commits) {
+        Bump::None => None,
+        Bump::Major if major == 0 => Some((0, minor + 1, 0)),
+        Bump::Major => Some((major + 1, 0, 0)),
+        Bump::Minor => Some((major, minor + 1, 0)),
+        Bump::Patch => Some((major, minor, patch + 1)),
+    };
+    Ok((next.map(|(x, y, z)| format!("{x}.{y}.{z}")), commits.len()))
+}
+
+/// The next version alone, for scripts; an error when nothing since the last tag calls
+/// for a release.
+pub async fn run(client: &Query, repo: Directory, range: Option<&str>) -> eyre::Result<String> {
+    match next(client, repo, range).await? {
+        (Some(version), _) => Ok(version),
+        (None, count) => Err(eyre::eyre!(
+            "[next-version] None of {count} commit(s) is a feat, fix, perf or breaking \
+             change; nothing to release"
+        )),
+    }
+}
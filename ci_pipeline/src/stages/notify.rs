@@ -0,0 +1,99 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use clap::ValueEnum;
+use dagger_sdk::Query;
+use serde_json::json;
+
+use crate::pipeline::CiReport;
+
+const CURL_IMAGE: &str = "curlimages/curl:8.11.1";
+
+/// Host variable holding the incoming-webhook URL, which embeds its own credential and
+/// so is passed to the container as a secret.
+const WEBHOOK_ENV: &str = "CI_WEBHOOK_URL";
+
+/// Chat service behind the webhook; each expects the message under a different key.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, ValueEnum)]
+pub enum WebhookKind {
+    Slack,
+    Discord,
+    Teams,
+}
+
+/// One-line status, the failing phases, each phase's duration and `link`.
+fn message(report: &CiReport, link: Option<&str>) -> String {
+    let failed: Vec<&str> = report
+        .phases
+        .iter()
+        .filter(|p| !p.passed)
+        .map(|p| p.name.as_str())
+        .collect();
+    let mut text = if report.passed {
+        format!("centrix-ci PASSED ({:.0}s)", report.duration_secs)
+    } else if failed.is_empty() {
+        format!("centrix-ci FAILED: phases cancelled ({:.0}s)", report.duration_secs)
+    } else {
+        format!("centrix-ci FAILED: {} ({:.0}s)", failed.join(", "), report.duration_secs)
+    };
+    text.push('\n');
+    for p in &report.phases {
+        let status = if p.passed { "ok" } else { "FAILED" };
+        text.push_str(&format!("{} {:.1}s {status}\n", p.name, p.duration_secs));
+    }
+    if let Some(link) = link {
+        text.push_str(link);
+    }
+    text
+}
+
+/// Post a summary of `report` to the webhook in `CI_WEBHOOK_URL`, with `link` (e.g. the
+/// CI run page) appended when given.
+pub async fn send(
+    client: &Query,
+    report: &CiReport,
+    kind: WebhookKind,
+    link: Option<&str>,
+) -> eyre::Result<String> {
+    let url = std::env::var(WEBHOOK_ENV).unwrap_or_default();
+    if url.is_empty() {
+        return Err(eyre::eyre!("[notify] {WEBHOOK_ENV} is not set"));
+    }
+
+    let text = message(report, link);
+    let payload = match kind {
+        WebhookKind::Slack | WebhookKind::Teams => json!({ "text": text }),
+        WebhookKind::Discord => json!({ "content": text }),
+    };
+
+    // Posting is a side effect; never let Dagger answer it from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    client
+        .container()
+        .from(CURL_IMAGE)
+        .with_new_file("/ci/payload.json", payload.to_string())
+        .with_secret_variable("WEBHOOK_URL", client.set_secret("webhook-url", url))
+        .with_env_variable("CI_NOTIFY_RUN", nonce.to_string())
+        .with_exec(vec![
+            "sh", "-c",
+            "curl -fsS -X POST -H 'Content-Type: application/json' \
+             --data @/ci/payload.json \"$WEBHOOK_URL\"",
+        ])
+        .sync()
+        .await
+        .map_err(|e| eyre::eyre!("[notify] Posting to the webhook failed:\n{e}"))?;
+
+    let status = if report.passed { "passed" } else { "failed" };
+    Ok(format!("[notify] Sent '{status}' summary to the {kind:?} webhook."))
+}
+
+/// Post a report saved from `all --json`; see `send`.
+pub async fn run(
+    client: &Query,
+    report_json: &str,
+    kind: WebhookKind,
+    link: Option<&str>,
+) -> eyre::Result<String> {
+    let report: CiReport = serde_json::from_str(report_json)
+        .map_err(|e| eyre::eyre!("[notify] Not an `all --json` report: {e}"))?;
+    send(client, &report, kind, link).await
+}
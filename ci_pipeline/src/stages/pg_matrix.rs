@@ -0,0 +1,63 @@
+use dagger_sdk::{Directory, Query};
+use futures::future::join_all;
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration::{self, Verbosity};
+
+/// Run the integration lifecycle against each PostgreSQL version concurrently and
+/// report pass/fail per version, failing if any version fails.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    versions: &[String],
+    verbosity: Verbosity,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let mut entries = Vec::new();
+    for version in versions {
+        let mut pg_opts = BaseOpts { postgres_version: version.clone(), ..opts.clone() };
+        if *version != opts.postgres_version {
+            // A pinned digest belongs to the configured version's tag.
+            pg_opts.pg_digest = None;
+        }
+        pg_opts.validate()?;
+        entries.push((version.as_str(), pg_opts));
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let results = join_all(entries.iter().map(|(_, pg_opts)| {
+        let steps = integration::default_steps();
+        let source = source.clone();
+        async move {
+            let pg = containers::ready_postgres(client, pg_opts).await?;
+            integration::lifecycle(
+                client, source, pg, containers::pg_url(client), &steps, verbosity, pg_opts,
+            )
+            .await
+        }
+    }))
+    .await;
+
+    let mut report = String::new();
+    let mut failed = Vec::new();
+    for ((version, _), result) in entries.iter().zip(&results) {
+        match result {
+            Ok(_) => report.push_str(&format!("  postgres {version}: ok\n")),
+            Err(err) => {
+                failed.push(*version);
+                report.push_str(&format!("  postgres {version}: FAILED\n{err}\n"));
+            }
+        }
+    }
+
+    if !failed.is_empty() {
+        return Err(eyre::eyre!(
+            "[pg-matrix] Lifecycle failed on PostgreSQL {}\n{report}",
+            failed.join(", ")
+        ));
+    }
+
+    Ok(format!("[pg-matrix] Lifecycle passed on every PostgreSQL version.\n{report}"))
+}
@@ -0,0 +1,122 @@
+use clap::Args;
+use dagger_sdk::{
+    Container, ContainerOpts, ContainerPublishOpts, Directory, File, Platform, Query,
+};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::sign::{self, SignOpts};
+use crate::stages::{build, build_cross, image_scan, integration};
+
+/// Image platforms and how the binary for each is produced. The engine is assumed to
+/// be amd64, so amd64 is a native release build and arm64 is cross-compiled.
+const PLATFORMS: &[&str] = &["linux/amd64", "linux/arm64"];
+
+fn platform_binary(
+    client: &Query,
+    source: Directory,
+    platform: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<File> {
+    match platform {
+        "linux/amd64" => Ok(build::binary(client, source, opts)),
+        "linux/arm64" => build_cross::binary(client, source, "aarch64-unknown-linux-gnu", opts),
+        other => Err(eyre::eyre!("no binary build for platform '{other}'")),
+    }
+}
+
+/// Minimal runtime image for `platform`: debian-slim with libpq, the `erp-server`
+/// binary and the web static assets, serving on `SERVER_PORT`.
+pub fn runtime_image(
+    client: &Query,
+    source: Directory,
+    platform: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<Container> {
+    let binary = platform_binary(client, source.clone(), platform, opts)?;
+
+    Ok(client
+        .container_opts(ContainerOpts { platform: Some(Platform(platform.to_string())) })
+        .from("debian:bookworm-slim")
+        .with_exec(containers::retried(&["apt-get", "update"], opts))
+        .with_exec(containers::retried(
+            &[
+                "apt-get", "install", "-y", "--no-install-recommends",
+                "libpq5", "ca-certificates",
+            ],
+            opts,
+        ))
+        .with_file("/usr/local/bin/erp-server", binary)
+        .with_directory("/app/erp_web/static", source.directory("erp_web/static"))
+        .with_workdir("/app")
+        .with_exposed_port(integration::SERVER_PORT)
+        .with_entrypoint(vec!["erp-server"])
+        .with_default_args(vec!["serve"]))
+}
+
+/// Where images are pushed, and whether they are signed.
+#[derive(Args, Clone, Debug)]
+pub struct PublishTarget {
+    #[arg(long, default_value = "ghcr.io")]
+    pub registry: String,
+    #[arg(long, default_value = "centrixsystems/erp-server")]
+    pub repository: String,
+    /// Registry user; the password is read from REGISTRY_PASSWORD
+    #[arg(long)]
+    pub username: String,
+    #[command(flatten)]
+    pub signing: SignOpts,
+}
+
+/// Build the runtime image for every platform in `PLATFORMS`, scan each with Trivy, and
+/// push them as one multi-arch manifest to `registry/repository:tag`, authenticating as
+/// `username`, then sign it when `--sign` is set; see `sign::sign`.
+/// Requires REGISTRY_PASSWORD environment variable.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    target: &PublishTarget,
+    tag: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let PublishTarget { registry, repository, username, signing } = target;
+    let password = std::env::var("REGISTRY_PASSWORD").unwrap_or_default();
+    if password.is_empty() {
+        return Err(eyre::eyre!("REGISTRY_PASSWORD environment variable not set"));
+    }
+    signing.check()?;
+    let password = client.set_secret("registry-password", password);
+
+    // Every variant passes the Trivy gate before anything is pushed.
+    let allowlist = image_scan::allowlist_or_default(&source, None).await?;
+    let mut variants = Vec::new();
+    for platform in PLATFORMS {
+        let image = runtime_image(client, source.clone(), platform, opts)?;
+        image_scan::scan(client, &image, allowlist.clone())
+            .await
+            .map_err(|e| eyre::eyre!("[publish] {platform} image failed the scan: {e}"))?;
+        variants.push(image.id().await?);
+    }
+
+    let address = format!("{registry}/{repository}:{tag}");
+    let published = client
+        .container()
+        .with_registry_auth(registry.as_str(), username.as_str(), password.clone())
+        .publish_opts(
+            address.as_str(),
+            ContainerPublishOpts {
+                platform_variants: Some(variants),
+                forced_compression: None,
+                media_types: None,
+            },
+        )
+        .await?;
+
+    let mut out = format!("[publish] Pushed {} ({}).", published, PLATFORMS.join(", "));
+    if let Some(mode) = signing.sign {
+        let signed = sign::sign(client, source, &published, mode, username, password, opts)
+            .await
+            .map_err(|e| eyre::eyre!("[publish] Pushed {published} but {e}"))?;
+        out.push_str(&format!("\n{signed}"));
+    }
+    Ok(out)
+}
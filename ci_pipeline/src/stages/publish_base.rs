@@ -0,0 +1,35 @@
+use dagger_sdk::Query;
+
+use crate::containers::{self, BaseOpts};
+
+/// Bake `containers::toolchain_image` (the Rust base image with the system build
+/// packages and the selected channel) into `registry/repository:tag`, for use with
+/// `--base-image`. The tag defaults to the Rust version, suffixed with the channel off
+/// stable. Requires REGISTRY_PASSWORD environment variable.
+pub async fn run(
+    client: &Query,
+    registry: &str,
+    repository: &str,
+    tag: Option<&str>,
+    username: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let password = std::env::var("REGISTRY_PASSWORD").unwrap_or_default();
+    if password.is_empty() {
+        return Err(eyre::eyre!("REGISTRY_PASSWORD environment variable not set"));
+    }
+    let password = client.set_secret("registry-password", password);
+
+    let tag = match (tag, opts.rust_channel) {
+        (Some(tag), _) => tag.to_string(),
+        (None, containers::RustChannel::Stable) => opts.rust_version.clone(),
+        (None, channel) => format!("{}-{}", opts.rust_version, channel.name()),
+    };
+    let address = format!("{registry}/{repository}:{tag}");
+    let published = containers::toolchain_image(client, opts)
+        .with_registry_auth(registry, username, password)
+        .publish(address.as_str())
+        .await?;
+
+    Ok(format!("[publish-base-image] Pushed {published}; use it with --base-image {address}."))
+}
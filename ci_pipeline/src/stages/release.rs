@@ -0,0 +1,208 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use clap::Args;
+use dagger_sdk::{Directory, Query};
+use serde_json::json;
+
+use crate::containers::BaseOpts;
+use crate::git::{self, Commit};
+use crate::pipeline::{self, Phase};
+use crate::stages::publish::{self, PublishTarget};
+use crate::stages::{build, build_cross, next_version};
+
+const CURL_IMAGE: &str = "curlimages/curl:8.11.1";
+
+/// Host variable holding a token with `contents: write` on the repository.
+const TOKEN_ENV: &str = "GITHUB_TOKEN";
+
+/// Changelog sections by Conventional Commits type, in display order. Breaking changes
+/// get their own section ahead of these; other types are left out.
+const SECTIONS: &[(&str, &str)] = &[
+    ("feat", "Features"),
+    ("fix", "Bug Fixes"),
+    ("perf", "Performance"),
+];
+
+/// Creates the draft release from `/ci/release.json`, printing the response.
+const CREATE_SCRIPT: &str = r#"
+curl -fsS -X POST "$API_URL/repos/$REPO/releases" \
+    -H "Authorization: Bearer $GITHUB_TOKEN" \
+    -H "Accept: application/vnd.github+json" \
+    --data @/ci/release.json
+"#;
+
+/// Uploads every file in `/ci/assets` plus their `SHA256SUMS` to draft `$RELEASE_ID`,
+/// then publishes it, which creates the tag.
+const UPLOAD_SCRIPT: &str = r#"
+set -e
+cd /ci/assets
+sha256sum * > SHA256SUMS
+for asset in *; do
+    curl -fsS -X POST "$UPLOAD_URL/repos/$REPO/releases/$RELEASE_ID/assets?name=$asset" \
+        -H "Authorization: Bearer $GITHUB_TOKEN" \
+        -H "Content-Type: application/octet-stream" \
+        --data-binary @"$asset" -o /dev/null -w "%{http_code} $asset\n"
+done
+curl -fsS -X PATCH "$API_URL/repos/$REPO/releases/$RELEASE_ID" \
+    -H "Authorization: Bearer $GITHUB_TOKEN" \
+    -H "Accept: application/vnd.github+json" \
+    --data '{"draft": false}' -o /dev/null -w "%{http_code} published\n"
+"#;
+
+/// Semver `version` without a leading `v`, or an error if it is not `X.Y.Z[-pre]`.
+fn normalize(version: &str) -> eyre::Result<&str> {
+    let version = version.strip_prefix('v').unwrap_or(version);
+    let core = version.split(['-', '+']).next().unwrap_or_default();
+    let parts: Vec<&str> = core.split('.').collect();
+    if parts.len() != 3 || parts.iter().any(|p| p.is_empty() || p.parse::<u64>().is_err()) {
+        return Err(eyre::eyre!("invalid version '{version}', expected X.Y.Z[-pre]"));
+    }
+    Ok(version)
+}
+
+/// Markdown changelog of `commits`, grouped into breaking changes and `SECTIONS`.
+pub fn changelog(commits: &[Commit]) -> String {
+    let entry = |commit: &Commit| {
+        let c = commit.conventional()?;
+        let scope = c.scope.map(|s| format!("**{s}:** ")).unwrap_or_default();
+        Some(format!("- {scope}{} ({})\n", c.description, commit.short_sha()))
+    };
+
+    let mut out = String::new();
+    let breaking: String = commits
+        .iter()
+        .filter(|c| c.conventional().is_some_and(|c| c.breaking))
+        .filter_map(entry)
+        .collect();
+    if !breaking.is_empty() {
+        out.push_str(&format!("### Breaking Changes\n\n{breaking}\n"));
+    }
+    for (kind, title) in SECTIONS {
+        let entries: String = commits
+            .iter()
+            .filter(|c| c.conventional().is_some_and(|c| c.kind == *kind))
+            .filter_map(entry)
+            .collect();
+        if !entries.is_empty() {
+            out.push_str(&format!("### {title}\n\n{entries}\n"));
+        }
+    }
+    if out.is_empty() {
+        out.push_str("No user-facing changes.\n");
+    }
+    out
+}
+
+/// Where the release is published.
+#[derive(Args, Clone, Debug)]
+pub struct ReleaseTarget {
+    /// GitHub repository as owner/name
+    #[arg(long)]
+    pub repo: String,
+    #[command(flatten)]
+    pub image: PublishTarget,
+}
+
+/// Cut release `version` of `HEAD`, by default the one `next-version` computes:
+///
+/// 1. Run every phase of `All`, stopping at the first failure.
+/// 2. Build `erp-server` for x86_64 (glibc) and every `build-cross` target.
+/// 3. Scan, push and, with `--sign`, sign the multi-arch image as
+///    `registry/repository:<version>`; see `publish`.
+/// 4. Create GitHub release `v<version>` with a changelog of the Conventional Commits
+///    since the previous tag, the binaries and their `SHA256SUMS` attached.
+///
+/// The release is created as a draft and only published, creating the tag, once every
+/// asset is uploaded. Needs `GITHUB_TOKEN` and `REGISTRY_PASSWORD`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    repo: Directory,
+    version: Option<&str>,
+    target: &ReleaseTarget,
+    concurrency: usize,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let version = match version {
+        Some(version) => normalize(version)?.to_string(),
+        None => next_version::next(client, repo.clone(), None).await?.0.ok_or_else(|| {
+            eyre::eyre!("[release] No feat, fix, perf or breaking commit since the last tag")
+        })?,
+    };
+    let version = version.as_str();
+    let tag = format!("v{version}");
+    let token = std::env::var(TOKEN_ENV).unwrap_or_default();
+    if token.is_empty() {
+        return Err(eyre::eyre!("[release] {TOKEN_ENV} is not set"));
+    }
+
+    let results =
+        pipeline::execute(client, source.clone(), &Phase::ALL, &[], concurrency, true, opts)
+            .await;
+    pipeline::summary(&results, Phase::ALL.len())
+        .map_err(|e| eyre::eyre!("[release] Gate failed, nothing released:\n{e}"))?;
+
+    let mut assets = client.directory().with_file(
+        format!("erp-server-{version}-x86_64-unknown-linux-gnu"),
+        build::binary(client, source.clone(), opts),
+    );
+    for triple in build_cross::triples() {
+        let binary = build_cross::binary(client, source.clone(), triple, opts)?;
+        assets = assets.with_file(format!("erp-server-{version}-{triple}"), binary);
+    }
+    // Build every asset before anything becomes public.
+    assets.sync().await?;
+
+    let pushed = publish::run(client, source, &target.image, version, opts).await?;
+
+    let previous = git::last_tag(client, repo.clone()).await?;
+    let range = match &previous {
+        Some(prev) => format!("{prev}..HEAD"),
+        None => "HEAD".to_string(),
+    };
+    let commits = git::log(client, repo.clone(), &range).await?;
+    let sha = git::head(client, repo).await?;
+    let body = json!({
+        "tag_name": tag,
+        "target_commitish": sha,
+        "name": tag,
+        "body": changelog(&commits),
+        "draft": true,
+        "prerelease": version.contains('-'),
+    });
+
+    // Creating and uploading are side effects; never let Dagger answer them from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let curl = client
+        .container()
+        .from(CURL_IMAGE)
+        .with_secret_variable("GITHUB_TOKEN", client.set_secret("github-token", token))
+        .with_env_variable("API_URL", "https://api.github.com")
+        .with_env_variable("UPLOAD_URL", "https://uploads.github.com")
+        .with_env_variable("REPO", target.repo.as_str())
+        .with_env_variable("CI_RELEASE_RUN", nonce.to_string());
+    let created = curl
+        .with_new_file("/ci/release.json", body.to_string())
+        .with_exec(vec!["sh", "-c", CREATE_SCRIPT])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[release] Creating release {tag} failed:\n{e}"))?;
+    let created: serde_json::Value = serde_json::from_str(&created)?;
+    let id = created["id"]
+        .as_u64()
+        .ok_or_else(|| eyre::eyre!("[release] No release id in GitHub's response"))?;
+
+    let uploaded = curl
+        .with_directory("/ci/assets", assets)
+        .with_env_variable("RELEASE_ID", id.to_string())
+        .with_exec(vec!["sh", "-c", UPLOAD_SCRIPT])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[release] Uploading to draft release {id} failed:\n{e}"))?;
+
+    let since = previous.as_deref().unwrap_or("the first commit");
+    Ok(format!(
+        "[release] Released {tag} ({} commit(s) since {since}).\n{pushed}\n{uploaded}",
+        commits.len()
+    ))
+}
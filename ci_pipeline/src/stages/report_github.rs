@@ -0,0 +1,202 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+use serde::Serialize;
+use serde_json::json;
+
+use crate::containers::BaseOpts;
+use crate::pipeline::{self, Phase, PhaseResult};
+
+const CURL_IMAGE: &str = "curlimages/curl:8.11.1";
+
+/// Host variable holding the token statuses and the check run are posted with. Commit
+/// statuses need `repo:status`; check runs can only be created by a GitHub App
+/// installation token (e.g. the workflow's `GITHUB_TOKEN` with `checks: write`).
+const TOKEN_ENV: &str = "GITHUB_TOKEN";
+
+/// Name of the check run, and prefix of the per-phase status contexts.
+const CHECK_NAME: &str = "centrix-ci";
+
+/// The Checks API accepts at most this many annotations per request.
+const MAX_ANNOTATIONS: usize = 50;
+
+/// GitHub caps status descriptions at 140 characters.
+const MAX_DESCRIPTION: usize = 140;
+
+/// Posts every `/ci/statuses/*.json` as a commit status, then `/ci/check-run.json` as a
+/// check run, printing each response's `html_url` or error.
+const POST_SCRIPT: &str = r#"
+set -e
+post() {
+    curl -fsS -X POST "$1" \
+        -H "Authorization: Bearer $GITHUB_TOKEN" \
+        -H "Accept: application/vnd.github+json" \
+        -H "X-GitHub-Api-Version: 2022-11-28" \
+        --data @"$2" -o /dev/null -w "%{http_code} $2\n"
+}
+for status in /ci/statuses/*.json; do
+    post "$API_URL/repos/$REPO/statuses/$SHA" "$status"
+done
+post "$API_URL/repos/$REPO/check-runs" /ci/check-run.json
+"#;
+
+/// A Checks API annotation pointing at a line of the workspace.
+#[derive(Debug, Serialize)]
+struct Annotation {
+    path: String,
+    start_line: u32,
+    end_line: u32,
+    annotation_level: &'static str,
+    title: String,
+    message: String,
+}
+
+/// Annotations for rustc/clippy diagnostics (`error: ..` or `warning: ..` directly
+/// followed by `--> path:line:col`) and test panics (`panicked at path:line:col:`
+/// followed by the message) in a phase's output.
+fn annotations(phase: Phase, log: &str) -> Vec<Annotation> {
+    let lines: Vec<&str> = log.lines().collect();
+    let mut found = Vec::new();
+    for (i, pair) in lines.windows(2).enumerate() {
+        let (first, second) = (pair[0].trim(), pair[1].trim());
+        if let Some(location) = second.strip_prefix("--> ") {
+            let level = if first.starts_with("error") {
+                "failure"
+            } else if first.starts_with("warning") {
+                "warning"
+            } else {
+                continue;
+            };
+            let Some((path, line)) = file_line(location) else { continue };
+            let message = first.split_once(": ").map_or(first, |(_, m)| m);
+            found.push(Annotation {
+                path,
+                start_line: line,
+                end_line: line,
+                annotation_level: level,
+                title: phase.name().to_string(),
+                message: message.to_string(),
+            });
+        } else if let Some((_, location)) = first.split_once("panicked at ") {
+            let Some((path, line)) = file_line(location.trim_end_matches(':')) else {
+                continue;
+            };
+            let thread = first.split('\'').nth(1).unwrap_or("test");
+            found.push(Annotation {
+                path,
+                start_line: line,
+                end_line: line,
+                annotation_level: "failure",
+                title: format!("{thread} panicked"),
+                message: lines.get(i + 1).unwrap_or(&"").trim().to_string(),
+            });
+        }
+    }
+    found
+}
+
+/// `path` and `line` of a `path:line:col` location.
+fn file_line(location: &str) -> Option<(String, u32)> {
+    let mut parts = location.rsplitn(3, ':');
+    let _col = parts.next()?;
+    let line = parts.next()?.parse().ok()?;
+    let path = parts.next()?.trim_start_matches("/app/");
+    Some((path.to_string(), line))
+}
+
+/// Commit status body for one phase result.
+fn status(result: &PhaseResult) -> serde_json::Value {
+    let (state, description) = match &result.error {
+        None => ("success", format!("passed in {:.0}s", result.duration.as_secs_f64())),
+        Some(err) => ("failure", err.lines().next().unwrap_or("failed").to_string()),
+    };
+    json!({
+        "state": state,
+        "context": format!("{CHECK_NAME}/{}", result.phase.name()),
+        "description": description.chars().take(MAX_DESCRIPTION).collect::<String>(),
+    })
+}
+
+/// Check run body summarising `results`, with annotations from the failed phases' output.
+fn check_run(results: &[PhaseResult], expected: usize, sha: &str) -> serde_json::Value {
+    let failed: Vec<&str> =
+        results.iter().filter(|r| !r.passed).map(|r| r.phase.name()).collect();
+    let passed = failed.is_empty() && results.len() == expected;
+    let title = if passed {
+        format!("{expected} phase(s) passed")
+    } else if failed.is_empty() {
+        format!("{} phase(s) cancelled", expected - results.len())
+    } else {
+        format!("{} of {expected} phase(s) failed: {}", failed.len(), failed.join(", "))
+    };
+
+    let mut annotations: Vec<Annotation> = results
+        .iter()
+        .filter_map(|r| Some(annotations(r.phase, r.error.as_deref()?)))
+        .flatten()
+        .collect();
+    let dropped = annotations.len().saturating_sub(MAX_ANNOTATIONS);
+    annotations.truncate(MAX_ANNOTATIONS);
+
+    let mut summary = format!("```\n{}```\n", pipeline::timing_table(results).trim_start());
+    if dropped > 0 {
+        summary.push_str(&format!("\n{dropped} further annotation(s) omitted.\n"));
+    }
+    json!({
+        "name": CHECK_NAME,
+        "head_sha": sha,
+        "status": "completed",
+        "conclusion": if passed { "success" } else { "failure" },
+        "output": { "title": title, "summary": summary, "annotations": annotations },
+    })
+}
+
+/// Run every phase of `All`, then post one commit status per phase (`centrix-ci/<phase>`)
+/// and a `centrix-ci` check run on `sha` in `repo` (`owner/name`), with clippy
+/// diagnostics and test panics from failed phases as annotations on the PR. The token
+/// comes from `GITHUB_TOKEN`. Results are posted even when phases fail; the summary
+/// error is returned after.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    repo: &str,
+    sha: &str,
+    api_url: &str,
+    concurrency: usize,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let token = std::env::var(TOKEN_ENV).unwrap_or_default();
+    if token.is_empty() {
+        return Err(eyre::eyre!("[report-github] {TOKEN_ENV} is not set"));
+    }
+
+    let results =
+        pipeline::execute(client, source, &Phase::ALL, &[], concurrency, false, opts).await;
+
+    let mut payloads = client
+        .directory()
+        .with_new_file("check-run.json", check_run(&results, Phase::ALL.len(), sha).to_string());
+    for r in &results {
+        payloads = payloads
+            .with_new_file(format!("statuses/{}.json", r.phase.name()), status(r).to_string());
+    }
+
+    // Posting is a side effect; never let Dagger answer it from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let posted = client
+        .container()
+        .from(CURL_IMAGE)
+        .with_directory("/ci", payloads)
+        .with_secret_variable("GITHUB_TOKEN", client.set_secret("github-token", token))
+        .with_env_variable("API_URL", api_url.trim_end_matches('/'))
+        .with_env_variable("REPO", repo)
+        .with_env_variable("SHA", sha)
+        .with_env_variable("CI_REPORT_RUN", nonce.to_string())
+        .with_exec(vec!["sh", "-c", POST_SCRIPT])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[report-github] Posting to GitHub failed:\n{e}"))?;
+
+    let summary = pipeline::summary(&results, Phase::ALL.len())?;
+    Ok(format!("{summary}\n[report-github] Posted to {repo}@{sha}:\n{posted}"))
+}
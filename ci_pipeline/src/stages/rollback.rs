@@ -0,0 +1,110 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, File, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+/// Diesel migration directory for the failing migration; sorts after every real migration.
+const BAD_MIGRATION_DIR: &str = "9999-12-31-235959_ci_rollback_test";
+const BAD_MIGRATION_VERSION: &str = "99991231235959";
+
+/// Default fixture: creates a probe table, then errors before the transaction commits.
+const DEFAULT_BAD_MIGRATION: &str = r#"CREATE TABLE ci_rollback_probe (id INTEGER PRIMARY KEY);
+INSERT INTO ci_rollback_probe VALUES (1);
+SELECT 1 / 0;
+"#;
+
+/// Bash `snapshot` function: column and index definitions of the public schema, one
+/// per line, for diffing the schema before and after a migration step.
+pub const SNAPSHOT_FN: &str = r#"
+snapshot() {
+    psql "$DATABASE_URL" -At -c "SELECT table_name || '.' || column_name || ' ' || data_type || ' ' || is_nullable FROM information_schema.columns WHERE table_schema = 'public' ORDER BY 1"
+    psql "$DATABASE_URL" -At -c "SELECT indexdef FROM pg_indexes WHERE schemaname = 'public' ORDER BY 1"
+}
+"#;
+
+/// Runs with `$BINARY` set to the `erp-server` built in the stage's own target dir.
+const TEST_SCRIPT: &str = r#"
+set -euo pipefail
+
+echo "=== Rollback Test: Failing Migration ==="
+
+echo "[1/5] Applying baseline migrations..."
+$BINARY migrate 2>&1
+
+echo "[2/5] Snapshotting schema..."
+snapshot > /tmp/schema-before.txt
+echo "$(wc -l < /tmp/schema-before.txt) schema entries"
+
+echo "[3/5] Adding failing migration $BAD_MIGRATION_DIR..."
+mkdir -p "$MIGRATIONS_DIR/$BAD_MIGRATION_DIR"
+cp /ci/bad-migration.sql "$MIGRATIONS_DIR/$BAD_MIGRATION_DIR/up.sql"
+echo "SELECT 1;" > "$MIGRATIONS_DIR/$BAD_MIGRATION_DIR/down.sql"
+cargo build --release --package erp_server 2>&1 | tail -3
+
+echo "[4/5] Running migrations (expected to fail)..."
+if $BINARY migrate 2>&1; then
+    echo "FAIL: failing migration was reported as applied"
+    exit 1
+fi
+
+echo "[5/5] Verifying rollback..."
+snapshot > /tmp/schema-after.txt
+if ! diff -u /tmp/schema-before.txt /tmp/schema-after.txt; then
+    echo "FAIL: schema changed after failed migration"
+    exit 1
+fi
+APPLIED=$(psql "$DATABASE_URL" -At -c "SELECT COUNT(*) FROM __diesel_schema_migrations WHERE version = '$BAD_MIGRATION_VERSION'")
+if [ "$APPLIED" != "0" ]; then
+    echo "FAIL: failed migration recorded as applied"
+    exit 1
+fi
+echo "Schema unchanged, migration not recorded."
+
+echo ""
+echo "=== Rollback Test Complete ==="
+"#;
+
+/// Verify a migration failing midway rolls back cleanly: the schema matches the
+/// pre-migration snapshot and the migration is not marked as applied.
+/// `bad_migration` replaces the default fixture, which errors after creating a table.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    bad_migration: Option<File>,
+    migrations_dir: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    // The rebuilt binary carries the failing migration: keep it out of the shared target
+    // dir, or every later stage on this source would migrate with it.
+    let opts = &BaseOpts { target_subdir: Some("rollback".to_string()), ..opts.clone() };
+    let binary = format!("{}/release/erp-server", containers::target_dir(opts));
+
+    let pg = containers::ready_postgres(client, opts).await?;
+
+    let fixture = match bad_migration {
+        Some(file) => file,
+        None => client
+            .directory()
+            .with_new_file("bad-migration.sql", DEFAULT_BAD_MIGRATION)
+            .file("bad-migration.sql"),
+    };
+
+    // The stage migrates a live database; never let Dagger answer it from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let output = integration::server_env(client, source, pg, containers::pg_url(client), opts)
+        .with_file("/ci/bad-migration.sql", fixture)
+        .with_env_variable("MIGRATIONS_DIR", migrations_dir)
+        .with_env_variable("BAD_MIGRATION_DIR", BAD_MIGRATION_DIR)
+        .with_env_variable("BAD_MIGRATION_VERSION", BAD_MIGRATION_VERSION)
+        .with_env_variable("BINARY", binary)
+        .with_env_variable("CI_ROLLBACK_RUN", nonce.to_string())
+        .with_exec(vec!["bash", "-c", &format!("{SNAPSHOT_FN}{TEST_SCRIPT}")])
+        .stdout()
+        .await?;
+
+    Ok(format!("[rollback] {output}"))
+}
@@ -0,0 +1,77 @@
+use clap::ValueEnum;
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts, RustChannel};
+
+/// Target the tests are built for. Naming it keeps build scripts and proc macros, which
+/// run on the host, out of the sanitized build.
+const TARGET: &str = "x86_64-unknown-linux-gnu";
+
+#[derive(Clone, Copy, Debug, ValueEnum)]
+pub enum Sanitizer {
+    /// Out-of-bounds accesses, use-after-free and leaks
+    Address,
+    /// Data races; std is rebuilt instrumented, as TSan requires
+    Thread,
+    /// Leaks only, with less overhead than `address`
+    Leak,
+}
+
+impl Sanitizer {
+    fn name(self) -> &'static str {
+        match self {
+            Sanitizer::Address => "address",
+            Sanitizer::Thread => "thread",
+            Sanitizer::Leak => "leak",
+        }
+    }
+}
+
+/// Build and run the unit tests (`--lib`) of `packages`, or of the whole workspace when
+/// empty, with `-Zsanitizer=<sanitizer>` on the nightly toolchain (in a target subdir per
+/// sanitizer). Slow, and meant for the extended nightly pipeline rather than every PR.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    sanitizer: Sanitizer,
+    packages: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let name = sanitizer.name();
+    let opts = BaseOpts {
+        rust_channel: RustChannel::Nightly,
+        // A prebuilt image carries its own toolchain, not nightly.
+        base_image: None,
+        target_subdir: Some(format!("sanitize-{name}")),
+        ..opts.clone()
+    };
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), &opts), &opts)
+        .await?;
+
+    let mut toolchain = containers::rust_toolchain(client, &opts);
+    let mut cmd = vec!["cargo", "test", "--lib", "--target", TARGET];
+    if matches!(sanitizer, Sanitizer::Thread) {
+        toolchain = toolchain.with_exec(containers::retried(
+            &["rustup", "component", "add", "--toolchain", "nightly", "rust-src"],
+            &opts,
+        ));
+        cmd.push("-Zbuild-std");
+    }
+    if packages.is_empty() {
+        cmd.push("--workspace");
+    }
+    for package in packages {
+        cmd.extend(["-p", package.as_str()]);
+    }
+
+    let flags = format!("-Zsanitizer={name}");
+    let output = containers::with_source(toolchain, source)
+        .with_env_variable("RUSTFLAGS", flags.as_str())
+        .with_env_variable("RUSTDOCFLAGS", flags.as_str())
+        .with_exec(cmd)
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[sanitize] {name} sanitizer reported errors:\n{e}"))?;
+
+    Ok(format!("[sanitize] Unit tests passed under the {name} sanitizer.\n{output}"))
+}
@@ -0,0 +1,137 @@
+use std::collections::BTreeSet;
+
+use dagger_sdk::{Directory, File, Query};
+use serde_json::{json, Value};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::clippy_json::CargoMessage;
+use crate::stages::module_lint::{self, Severity};
+
+/// Clippy with the `lint` stage's flags, as JSON; exits 0 even on errors so they are
+/// reported instead of aborting the export.
+const CLIPPY_SCRIPT: &str = "cargo clippy --workspace --lib --message-format=json \
+    -- -D clippy::correctness -W clippy::all || true";
+
+/// A SARIF result: rule, level (`error` or `warning`), message and location. `line`
+/// and `column` are 1-based; a result without a line covers the whole file.
+fn result(
+    rule: &str,
+    level: &str,
+    message: &str,
+    file: &str,
+    line: Option<usize>,
+    column: Option<usize>,
+) -> Value {
+    let mut location = json!({ "artifactLocation": { "uri": file } });
+    if let Some(line) = line {
+        location["region"] = json!({ "startLine": line, "startColumn": column.unwrap_or(1) });
+    }
+    json!({
+        "ruleId": rule,
+        "level": level,
+        "message": { "text": message },
+        "locations": [{ "physicalLocation": location }],
+    })
+}
+
+/// A SARIF run of `tool` with `results`, declaring every rule they reference.
+fn run_of(tool: &str, info_uri: Option<&str>, results: Vec<Value>) -> Value {
+    let rules: BTreeSet<&str> = results.iter().filter_map(|r| r["ruleId"].as_str()).collect();
+    let rules: Vec<Value> = rules.into_iter().map(|id| json!({ "id": id })).collect();
+    let mut driver = json!({ "name": tool, "rules": rules });
+    if let Some(uri) = info_uri {
+        driver["informationUri"] = json!(uri);
+    }
+    json!({ "tool": { "driver": driver }, "results": results })
+}
+
+/// Clippy errors and warnings at their primary span.
+async fn clippy_results(
+    client: &Query,
+    source: Directory,
+    opts: &BaseOpts,
+) -> eyre::Result<Vec<Value>> {
+    let output = containers::rust_base(client, source, opts)
+        .with_exec(vec!["sh", "-c", CLIPPY_SCRIPT])
+        .stdout()
+        .await?;
+
+    let mut results = Vec::new();
+    for line in output.lines() {
+        let Ok(msg) = serde_json::from_str::<CargoMessage>(line) else {
+            continue;
+        };
+        let Some(diag) = msg.message.filter(|_| msg.reason == "compiler-message") else {
+            continue;
+        };
+        if diag.level != "error" && diag.level != "warning" {
+            continue;
+        }
+        let Some(span) = diag.spans.iter().find(|s| s.is_primary) else {
+            continue;
+        };
+        let rule = diag.code.map(|c| c.code).unwrap_or_else(|| "rustc".into());
+        results.push(result(
+            &rule,
+            &diag.level,
+            &diag.message,
+            &span.file_name,
+            Some(span.line_start),
+            Some(span.column_start),
+        ));
+    }
+    Ok(results)
+}
+
+/// Run clippy and module lint and export their findings to `output` as one SARIF 2.1.0
+/// log with a run per tool, for upload to GitHub code scanning. Findings do not fail
+/// the stage; code scanning decides what blocks a PR.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    schema: Option<File>,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let (clippy, lint) = futures::try_join!(
+        clippy_results(client, source.clone(), opts),
+        module_lint::lint(client, &source, schema, opts),
+    )?;
+    let module_results: Vec<Value> = lint
+        .findings
+        .iter()
+        .map(|f| {
+            let level = match f.severity {
+                Severity::Error => "error",
+                Severity::Warning => "warning",
+            };
+            result(f.rule, level, &f.message, &f.file, f.line, None)
+        })
+        .collect();
+
+    let summary = format!(
+        "{} clippy and {} module lint finding(s)",
+        clippy.len(),
+        module_results.len()
+    );
+    let log = json!({
+        "$schema": "https://json.schemastore.org/sarif-2.1.0.json",
+        "version": "2.1.0",
+        "runs": [
+            run_of("clippy", Some("https://github.com/rust-lang/rust-clippy"), clippy),
+            run_of("centrix-module-lint", None, module_results),
+        ],
+    });
+
+    client
+        .directory()
+        .with_new_file("results.sarif", serde_json::to_string_pretty(&log)?)
+        .file("results.sarif")
+        .export(output)
+        .await?;
+
+    Ok(format!("[sarif] {summary} exported to {output}."))
+}
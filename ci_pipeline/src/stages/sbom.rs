@@ -0,0 +1,65 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::publish;
+
+const SYFT_IMAGE: &str = "anchore/syft:v1.18.1";
+
+/// Writes one CycloneDX JSON document per workspace crate and collects them under
+/// `/tmp/sbom` as `<crate>.cdx.json`.
+const CYCLONEDX_SCRIPT: &str = r#"
+set -euo pipefail
+cargo cyclonedx --format json --all
+mkdir -p /tmp/sbom
+find . -path ./target -prune -o -name '*.cdx.json' -print | while read -r bom; do
+    mv "$bom" /tmp/sbom/
+done
+"#;
+
+/// SBOMs of the workspace and of the amd64 runtime image:
+///
+/// - `workspace/<crate>.cdx.json`: CycloneDX from `cargo cyclonedx`, covering every
+///   crate compiled into the binaries.
+/// - `image.cdx.json`, `image.spdx.json`: CycloneDX and SPDX from syft, covering the
+///   image's OS packages and the binary.
+pub fn sboms(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<Directory> {
+    let toolchain = containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(&["cargo", "install", "cargo-cyclonedx", "--locked"], opts));
+    let workspace = containers::with_source(toolchain, source.clone())
+        .with_exec(vec!["bash", "-c", CYCLONEDX_SCRIPT])
+        .directory("/tmp/sbom");
+
+    let image = publish::runtime_image(client, source, "linux/amd64", opts)?;
+    let image_sboms = client
+        .container()
+        .from(SYFT_IMAGE)
+        .with_file("/ci/image.tar", image.as_tarball())
+        .with_directory("/out", client.directory())
+        .with_exec(vec![
+            "/syft", "scan", "docker-archive:/ci/image.tar",
+            "-o", "cyclonedx-json=/out/image.cdx.json",
+            "-o", "spdx-json=/out/image.spdx.json",
+        ])
+        .directory("/out");
+
+    Ok(image_sboms.with_directory("workspace", workspace))
+}
+
+/// Generate the SBOMs (see `sboms`) and export them to `output` on the host.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    output: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let sboms = sboms(client, source, opts)?;
+    let crates = sboms.glob("workspace/*.cdx.json").await?.len();
+    sboms.export(output).await?;
+
+    Ok(format!(
+        "[sbom] Image SBOM (CycloneDX, SPDX) and {crates} crate SBOM(s) exported to {output}."
+    ))
+}
@@ -0,0 +1,63 @@
+use dagger_sdk::{Directory, Query};
+use futures::stream::{self, StreamExt};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration::{self, Verbosity};
+use crate::stages::module_lifecycle::valid_identifier;
+
+/// Run the lifecycle of each of `modules` as its own scenario, up to `concurrency` at
+/// once, each against a PostgreSQL of its own; `erp-server` is built once and shared.
+/// Reports pass/fail per scenario, failing if any scenario fails.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    modules: &[String],
+    concurrency: usize,
+    verbosity: Verbosity,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    if let Some(module) = modules.iter().find(|m| !valid_identifier(m)) {
+        return Err(eyre::eyre!("[scenarios] invalid module name '{module}'"));
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let results: Vec<eyre::Result<String>> = stream::iter(modules)
+        .map(|module| {
+            let source = source.clone();
+            async move {
+                let pg = containers::ready_isolated_postgres(client, opts, module).await?;
+                let steps = integration::lifecycle_steps(module, &[], false);
+                let db_url = containers::pg_url(client);
+                integration::lifecycle(client, source, pg, db_url, &steps, verbosity, opts)
+                    .await
+            }
+        })
+        .buffered(concurrency.max(1))
+        .collect()
+        .await;
+
+    let mut report = String::new();
+    let mut failed = Vec::new();
+    for (module, result) in modules.iter().zip(&results) {
+        match result {
+            Ok(_) => report.push_str(&format!("  {module}: ok\n")),
+            Err(err) => {
+                failed.push(module.as_str());
+                report.push_str(&format!("  {module}: FAILED\n{err}\n"));
+            }
+        }
+    }
+
+    if !failed.is_empty() {
+        return Err(eyre::eyre!(
+            "[scenarios] {} of {} scenario(s) failed: {}\n{report}",
+            failed.len(),
+            modules.len(),
+            failed.join(", ")
+        ));
+    }
+
+    Ok(format!("[scenarios] {} scenario(s) passed.\n{report}", modules.len()))
+}
@@ -0,0 +1,34 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::BaseOpts;
+use crate::stages::migration;
+
+const DRIFT_SCRIPT: &str = r#"
+set -euo pipefail
+diesel migration run --migration-dir "$MIGRATIONS_DIR" > /dev/null
+diesel print-schema > /tmp/schema.rs
+if ! diff -u "$SCHEMA_PATH" /tmp/schema.rs; then
+    echo "FAIL: $SCHEMA_PATH does not match the migrated schema (regenerate with diesel print-schema)"
+    exit 1
+fi
+"#;
+
+/// Apply the migrations to a fresh database and diff `diesel print-schema` (which honours
+/// the workspace's `diesel.toml`) against the committed `schema_path`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    schema_path: &str,
+    migrations_dir: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    migration::diesel_env(client, source, opts)
+        .await?
+        .with_env_variable("SCHEMA_PATH", schema_path)
+        .with_env_variable("MIGRATIONS_DIR", migrations_dir)
+        .with_exec(vec!["bash", "-c", DRIFT_SCRIPT])
+        .stdout()
+        .await?;
+
+    Ok(format!("[schema-drift] {schema_path} matches the migrated schema."))
+}
@@ -0,0 +1,89 @@
+use dagger_sdk::{Directory, File, Query};
+use serde::Deserialize;
+
+use crate::severity::Severity;
+
+/// Severity of a gitleaks finding, derived from the rule that matched. `generic` rules
+/// such as `generic-api-key` catch most hard-coded keys and passwords, so they rank High;
+/// false positives belong in the allowlist.
+fn rule_severity(rule_id: &str) -> Severity {
+    let rule = rule_id.to_lowercase();
+    if rule.contains("private-key") {
+        Severity::Critical
+    } else if rule.starts_with("generic")
+        || ["api-key", "access-key", "token", "secret", "password"]
+            .iter()
+            .any(|k| rule.contains(k))
+    {
+        Severity::High
+    } else {
+        Severity::Medium
+    }
+}
+
+#[derive(Deserialize)]
+#[serde(rename_all = "PascalCase")]
+struct Finding {
+    #[serde(rename = "RuleID")]
+    rule_id: String,
+    file: String,
+    start_line: usize,
+    description: String,
+}
+
+/// Scan the source tree for committed secrets with gitleaks and fail on findings
+/// at or above `min_severity`. `allowlist` is a `.gitleaksignore` of finding fingerprints.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    min_severity: Severity,
+    allowlist: Option<File>,
+) -> eyre::Result<String> {
+    let mut args = vec![
+        "gitleaks", "dir", "/src",
+        "--report-format", "json",
+        "--report-path", "/tmp/gitleaks.json",
+        "--exit-code", "0",
+        "--no-banner",
+        "--redact",
+    ];
+
+    let mut container = client
+        .container()
+        .from("zricethezav/gitleaks:v8.21.2")
+        .with_directory("/src", source);
+    if let Some(allowlist) = allowlist {
+        container = container.with_file("/ci/.gitleaksignore", allowlist);
+        args.extend(["--gitleaks-ignore-path", "/ci/.gitleaksignore"]);
+    }
+
+    let report = container
+        .with_exec(args)
+        .file("/tmp/gitleaks.json")
+        .contents()
+        .await?;
+    let findings: Vec<Finding> = serde_json::from_str(&report)?;
+
+    let mut out = String::new();
+    let mut blocking = 0;
+    for f in &findings {
+        let severity = rule_severity(&f.rule_id);
+        if severity >= min_severity {
+            blocking += 1;
+        }
+        out.push_str(&format!(
+            "  {:?} {}:{} [{}] {}\n",
+            severity, f.file.trim_start_matches("/src/"), f.start_line, f.rule_id, f.description
+        ));
+    }
+
+    let summary = format!(
+        "{} finding(s), {blocking} at or above {min_severity:?}",
+        findings.len()
+    );
+    if blocking > 0 {
+        return Err(eyre::eyre!("[secret-scan] {summary}\n{out}"));
+    }
+
+    Ok(format!("[secret-scan] No blocking secrets ({summary}).\n{out}"))
+}
@@ -1,14 +1,144 @@
-use dagger_sdk::{Directory, Query};
+use dagger_sdk::{Container, Directory, Query};
+use serde::Deserialize;
 
-use crate::containers;
+use crate::containers::{self, BaseOpts};
+use crate::severity::{cvss_base_score, Severity};
 
-/// Run `cargo audit` to check for known vulnerabilities.
-pub async fn run(client: &Query, source: Directory) -> eyre::Result<String> {
-    let output = containers::rust_base(client, source)
-        .with_exec(vec!["cargo", "install", "cargo-audit"])
-        .with_exec(vec!["cargo", "audit"])
-        .stdout()
-        .await?;
+/// `cargo audit --json` output (the parts the pipeline reads).
+#[derive(Debug, Deserialize)]
+pub struct AuditReport {
+    pub vulnerabilities: Vulnerabilities,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct Vulnerabilities {
+    pub list: Vec<Vulnerability>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct Vulnerability {
+    pub advisory: Advisory,
+    pub package: AffectedPackage,
+    pub versions: PatchedVersions,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct Advisory {
+    pub id: String,
+    pub title: String,
+    /// CVSS v3 vector, when the advisory is scored.
+    pub cvss: Option<String>,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct AffectedPackage {
+    pub name: String,
+    pub version: String,
+}
+
+#[derive(Debug, Deserialize)]
+pub struct PatchedVersions {
+    pub patched: Vec<String>,
+}
+
+impl Vulnerability {
+    /// Severity from the advisory's CVSS vector; unscored advisories rate as high
+    /// so they still block at the default threshold.
+    pub fn severity(&self) -> Severity {
+        self.advisory
+            .cvss
+            .as_deref()
+            .and_then(cvss_base_score)
+            .map_or(Severity::High, Severity::of_score)
+    }
+
+    /// One-line `RUSTSEC-ID crate@version: title (patched: ...)` description.
+    pub fn describe(&self) -> String {
+        let patched = if self.versions.patched.is_empty() {
+            "no patched release".to_string()
+        } else {
+            format!("patched: {}", self.versions.patched.join(", "))
+        };
+        format!(
+            "{} {}@{}: {} ({patched})",
+            self.advisory.id, self.package.name, self.package.version, self.advisory.title
+        )
+    }
+}
+
+/// `cargo-audit` and `cargo-deny` on the toolchain layer, with the advisory databases
+/// both tools fetch kept in cache volumes so repeat runs only pull new advisories.
+pub fn audit_tools(client: &Query, opts: &BaseOpts) -> Container {
+    containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(&["cargo", "install", "cargo-audit", "--locked"], opts))
+        .with_exec(containers::retried(&["cargo", "install", "cargo-deny", "--locked"], opts))
+        .with_mounted_cache(
+            "/usr/local/cargo/advisory-db",
+            client.cache_volume("cargo-advisory-db"),
+        )
+        .with_mounted_cache(
+            "/usr/local/cargo/advisory-dbs",
+            client.cache_volume("cargo-deny-advisory-dbs"),
+        )
+}
+
+/// Check `Cargo.lock` against the RustSec advisory database with `cargo audit`, failing
+/// on vulnerabilities rated `min_severity` or above that are not in `ignore` (triaged
+/// RUSTSEC IDs). When the workspace has a `deny.toml`, `cargo deny check` also enforces
+/// its license, ban and advisory policy.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    ignore: &[String],
+    min_severity: Severity,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    if source.glob("Cargo.lock").await?.is_empty() {
+        return Err(eyre::eyre!(
+            "[security] Cargo.lock not found at the workspace root; \
+             cargo audit needs a lockfile (run `cargo generate-lockfile` and commit it)"
+        ));
+    }
+    let has_deny_toml = !source.glob("deny.toml").await?.is_empty();
+
+    let mut audit = String::from("cargo audit --json");
+    for id in ignore {
+        audit.push_str(&format!(" --ignore {id}"));
+    }
+    audit.push_str(" > /tmp/audit.json || true");
+
+    let audited = containers::with_source(audit_tools(client, opts), source)
+        .with_exec(vec!["sh", "-c", audit.as_str()]);
+    let report: AuditReport =
+        serde_json::from_str(&audited.file("/tmp/audit.json").contents().await?)?;
+
+    let mut out = String::new();
+    let mut blocking = 0;
+    for v in &report.vulnerabilities.list {
+        let severity = v.severity();
+        if severity >= min_severity {
+            blocking += 1;
+        }
+        out.push_str(&format!("  {severity:?} {}\n", v.describe()));
+    }
+    let summary = format!(
+        "{} vulnerable dependenc{}, {blocking} at or above {min_severity:?}, {} ID(s) ignored",
+        report.vulnerabilities.list.len(),
+        if report.vulnerabilities.list.len() == 1 { "y" } else { "ies" },
+        ignore.len()
+    );
+    if blocking > 0 {
+        return Err(eyre::eyre!("[security] {summary}\n{out}"));
+    }
+
+    let deny = if has_deny_toml {
+        audited
+            .with_exec(vec!["cargo", "deny", "check"])
+            .stdout()
+            .await?
+    } else {
+        "No deny.toml, skipping cargo deny.\n".to_string()
+    };
 
-    Ok(format!("[security] Audit passed.\n{output}"))
+    Ok(format!("[security] Audit passed ({summary}).\n{out}{deny}"))
 }
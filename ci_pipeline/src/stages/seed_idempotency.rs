@@ -0,0 +1,56 @@
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+const TEST_SCRIPT: &str = r#"
+set -euo pipefail
+
+BINARY="./target/release/erp-server"
+
+# "table count" for every table in the public schema, in table order.
+snapshot() {
+    query=$(psql "$DATABASE_URL" -At -c "SELECT string_agg(format('SELECT %L, COUNT(*) FROM public.%I', table_name, table_name), ' UNION ALL ' ORDER BY table_name) FROM information_schema.tables WHERE table_schema = 'public' AND table_type = 'BASE TABLE'")
+    psql "$DATABASE_URL" -At -F ' ' -c "$query" | sort
+}
+
+echo "=== Seed Idempotency Test ==="
+
+echo "[1/4] Running migrations..."
+$BINARY migrate 2>&1
+
+echo "[2/4] Seeding..."
+$BINARY seed 2>&1
+snapshot > /tmp/before.txt
+
+echo "[3/4] Seeding again..."
+if ! $BINARY seed 2>&1; then
+    echo "FAIL: second seed returned an error"
+    exit 1
+fi
+snapshot > /tmp/after.txt
+
+echo "[4/4] Comparing row counts of $(wc -l < /tmp/before.txt) tables..."
+if ! diff -u /tmp/before.txt /tmp/after.txt; then
+    echo "FAIL: second seed changed the row counts above"
+    exit 1
+fi
+
+echo ""
+echo "=== Seed Idempotency Test Complete ==="
+"#;
+
+/// Run `erp-server seed` twice against the same database and assert the second run
+/// succeeds without changing the row count of any table.
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts).await?;
+
+    let pg = containers::ready_postgres(client, opts).await?;
+
+    let output = integration::server_env(client, source, pg, containers::pg_url(client), opts)
+        .with_exec(vec!["bash", "-c", TEST_SCRIPT])
+        .stdout()
+        .await?;
+
+    Ok(format!("[seed-idempotency] {output}"))
+}
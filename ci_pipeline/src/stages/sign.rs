@@ -0,0 +1,122 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use clap::{Args, ValueEnum};
+use dagger_sdk::{Directory, Query, Secret};
+use serde_json::json;
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::sbom;
+
+const ALPINE_IMAGE: &str = "alpine:3.20";
+
+/// How images are signed.
+#[derive(Clone, Copy, Debug, PartialEq, Eq, ValueEnum)]
+pub enum SignMode {
+    /// With the key pair in COSIGN_PRIVATE_KEY (PEM) and COSIGN_PASSWORD
+    Key,
+    /// Keyless through Fulcio/Rekor, with the OIDC token in SIGSTORE_ID_TOKEN
+    Keyless,
+}
+
+/// Signing options of the publish path.
+#[derive(Args, Clone, Debug)]
+pub struct SignOpts {
+    /// Sign pushed images with cosign and attach SLSA provenance and SBOM attestations
+    #[arg(long, value_enum)]
+    pub sign: Option<SignMode>,
+}
+
+/// Logs in to `$REGISTRY`, signs `$IMAGE` and attests the provenance and SBOM
+/// predicates to it. `$KEY_ARGS` is empty for keyless signing.
+const SIGN_SCRIPT: &str = r#"
+set -e
+printf '%s' "$REGISTRY_PASSWORD" | cosign login "$REGISTRY" -u "$REGISTRY_USER" --password-stdin
+cosign sign --yes $KEY_ARGS "$IMAGE"
+cosign attest --yes $KEY_ARGS --type slsaprovenance --predicate /ci/provenance.json "$IMAGE"
+cosign attest --yes $KEY_ARGS --type cyclonedx --predicate /ci/sbom.cdx.json "$IMAGE"
+"#;
+
+/// Host variables holding `mode`'s credentials, all required.
+fn credentials(mode: SignMode) -> &'static [&'static str] {
+    match mode {
+        SignMode::Key => &["COSIGN_PRIVATE_KEY", "COSIGN_PASSWORD"],
+        SignMode::Keyless => &["SIGSTORE_ID_TOKEN"],
+    }
+}
+
+impl SignOpts {
+    /// Fail before anything is pushed when the credentials `--sign` needs are missing.
+    pub fn check(&self) -> eyre::Result<()> {
+        let Some(mode) = self.sign else { return Ok(()) };
+        let missing = credentials(mode)
+            .iter()
+            .find(|name| std::env::var(name).unwrap_or_default().is_empty());
+        match missing {
+            Some(name) => Err(eyre::eyre!("[sign] {name} is not set")),
+            None => Ok(()),
+        }
+    }
+}
+
+/// SLSA v0.2 provenance predicate for `image`, naming the base images it was built from.
+fn provenance(image: &str, opts: &BaseOpts) -> String {
+    json!({
+        "builder": { "id": "centrix-ci-pipeline" },
+        "buildType": "https://github.com/dagger/dagger",
+        "invocation": { "parameters": { "image": image } },
+        "materials": [
+            { "uri": containers::rust_image(opts) },
+            { "uri": "debian:bookworm-slim" },
+        ],
+    })
+    .to_string()
+}
+
+/// Sign `image` (a digest reference, as returned by publishing) with cosign and attach
+/// a SLSA provenance attestation and the CycloneDX SBOM of the runtime image. Credentials
+/// for `mode` are read from the host environment and passed as secrets; the registry is
+/// logged in to with the credentials the image was pushed with.
+pub async fn sign(
+    client: &Query,
+    source: Directory,
+    image: &str,
+    mode: SignMode,
+    username: &str,
+    password: Secret,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let registry = image.split('/').next().unwrap_or_default();
+    let sbom = sbom::sboms(client, source, opts)?.file("image.cdx.json");
+
+    let mut cosign = client
+        .container()
+        .from(ALPINE_IMAGE)
+        .with_exec(containers::retried(&["apk", "add", "--no-cache", "cosign"], opts))
+        .with_new_file("/ci/provenance.json", provenance(image, opts))
+        .with_file("/ci/sbom.cdx.json", sbom)
+        .with_secret_variable("REGISTRY_PASSWORD", password)
+        .with_env_variable("REGISTRY", registry)
+        .with_env_variable("REGISTRY_USER", username)
+        .with_env_variable("IMAGE", image);
+    for name in credentials(mode) {
+        let value = std::env::var(name).unwrap_or_default();
+        if value.is_empty() {
+            return Err(eyre::eyre!("[sign] {name} is not set"));
+        }
+        cosign = cosign.with_secret_variable(*name, client.set_secret(name.to_lowercase(), value));
+    }
+    if mode == SignMode::Key {
+        cosign = cosign.with_env_variable("KEY_ARGS", "--key env://COSIGN_PRIVATE_KEY");
+    }
+
+    // Signing uploads to the registry and the transparency log; never cache it.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    cosign
+        .with_env_variable("CI_SIGN_RUN", nonce.to_string())
+        .with_exec(vec!["sh", "-c", SIGN_SCRIPT])
+        .sync()
+        .await
+        .map_err(|e| eyre::eyre!("[sign] Signing {image} failed:\n{e}"))?;
+
+    Ok(format!("[sign] Signed {image} ({mode:?}) with provenance and SBOM attestations."))
+}
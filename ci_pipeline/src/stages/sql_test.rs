@@ -0,0 +1,85 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+const PGTAP_VERSION: &str = "1.3.3";
+
+/// Builds and installs pgTAP into the Alpine PostgreSQL image through PGXS.
+const INSTALL_PGTAP: &str = r#"
+set -e
+apk add --no-cache make perl
+wget -qO- "https://github.com/theory/pgtap/archive/refs/tags/v$PGTAP_VERSION.tar.gz" | tar -xz -C /tmp
+cd "/tmp/pgtap-$PGTAP_VERSION"
+make
+make install
+"#;
+
+/// Loads the extension into `$DATABASE_URL`, then runs every `*.sql` file under
+/// `/ci/tests` with `pg_prove`, which fails on any failing assertion or plan mismatch.
+const PROVE_SCRIPT: &str = r#"
+set -e
+psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -q -c "CREATE EXTENSION IF NOT EXISTS pgtap"
+pg_prove -d "$DATABASE_URL" --recurse --ext .sql --timer /ci/tests
+"#;
+
+/// Migrate and seed a PostgreSQL with pgTAP installed, then run the pgTAP tests under
+/// `tests` in the source tree with `pg_prove`, so constraints, triggers and stored
+/// procedures are tested directly against the real schema.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    tests: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let tests_dir = source.directory(tests);
+    let files = tests_dir.glob("**/*.sql").await?;
+    if files.is_empty() {
+        return Err(eyre::eyre!("[sql-test] No .sql files found under {tests}"));
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let pg = containers::postgres_container(client, opts)
+        .with_env_variable("PGTAP_VERSION", PGTAP_VERSION)
+        .with_exec(containers::retried(&["sh", "-c", INSTALL_PGTAP], opts))
+        .as_service();
+    containers::wait_for_service(&pg, "postgres", containers::SERVICE_READY_TIMEOUT).await?;
+    let db_url = containers::pg_url(client);
+
+    // The schema lives in the database, which Dagger can't see: a per-run nonce keeps a
+    // rerun from skipping the migrations against a fresh database.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    integration::server_env(client, source, pg.clone(), db_url.clone(), opts)
+        .with_env_variable("CI_SQL_TEST_RUN", nonce.to_string())
+        .with_exec(vec!["./target/release/erp-server", "migrate"])
+        .with_exec(vec!["./target/release/erp-server", "seed"])
+        .sync()
+        .await
+        .map_err(|e| eyre::eyre!("[sql-test] Preparing the database failed:\n{e}"))?;
+
+    let output = client
+        .container()
+        .from("debian:bookworm-slim")
+        .with_exec(containers::retried(&["apt-get", "update"], opts))
+        .with_exec(containers::retried(
+            &[
+                "apt-get", "install", "-y", "--no-install-recommends",
+                "postgresql-client", "libtap-parser-sourcehandler-pgtap-perl",
+            ],
+            opts,
+        ))
+        .with_service_binding("db", pg)
+        .with_secret_variable("DATABASE_URL", db_url)
+        .with_directory("/ci/tests", tests_dir)
+        .with_env_variable("CI_SQL_TEST_RUN", nonce.to_string())
+        .with_exec(vec!["sh", "-c", PROVE_SCRIPT])
+        .stdout()
+        .await
+        .map_err(|e| eyre::eyre!("[sql-test] pgTAP tests failed:\n{e}"))?;
+
+    Ok(format!("[sql-test] {} test file(s) passed.\n{output}", files.len()))
+}
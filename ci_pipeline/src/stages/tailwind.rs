@@ -1,21 +1,25 @@
-use dagger_sdk::{Directory, Query};
+use dagger_sdk::{Container, Directory, Query};
 
-use crate::containers;
+use crate::containers::{self, BaseOpts};
 
 /// Build Tailwind CSS v4 from erp_web/static/.
-pub async fn run(client: &Query, source: Directory) -> eyre::Result<String> {
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
     let static_dir = source.directory("erp_web/static");
 
-    let output = containers::node_base(client, static_dir)
-        .with_exec(vec!["npm", "ci"])
-        .with_exec(vec![
-            "npx", "@tailwindcss/cli",
-            "-i", "css/input.css",
-            "-o", "css/main.css",
-            "--minify",
-        ])
-        .stdout()
-        .await?;
+    let installed = containers::node_base(client, static_dir)
+        .with_exec(containers::retried(&["npm", "ci"], opts));
+    let output = compile(installed).stdout().await?;
 
     Ok(format!("[tailwind] CSS build complete.\n{output}"))
 }
+
+/// Compile `css/input.css` to a minified `css/main.css` in a `node_base` container
+/// with dependencies installed.
+pub fn compile(container: Container) -> Container {
+    container.with_exec(vec![
+        "npx", "@tailwindcss/cli",
+        "-i", "css/input.css",
+        "-o", "css/main.css",
+        "--minify",
+    ])
+}
@@ -1,13 +1,192 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use clap::Args;
 use dagger_sdk::{Directory, Query};
+use quick_xml::events::Event;
+use quick_xml::Reader;
+
+use crate::containers::{self, BaseOpts};
+
+/// Property-test controls passed to the test binaries through the environment.
+#[derive(Args, Clone, Debug, Default)]
+pub struct PropTestOpts {
+    /// proptest RNG seed; a fresh seed is picked (and printed) when unset
+    #[arg(long)]
+    pub proptest_seed: Option<u64>,
+    /// Cases per property (PROPTEST_CASES and QUICKCHECK_TESTS)
+    #[arg(long)]
+    pub proptest_cases: Option<u32>,
+    /// QUICKCHECK_TESTS alone, overriding --proptest-cases for quickcheck
+    #[arg(long)]
+    pub quickcheck_tests: Option<u32>,
+}
+
+/// Cases per property when unset: proptest's and quickcheck's own defaults.
+const PROPTEST_DEFAULT_CASES: u32 = 256;
+const QUICKCHECK_DEFAULT_TESTS: u32 = 100;
 
-use crate::containers;
+/// Nextest profile for CI: run every test even after a failure and write JUnit XML
+/// to `$CARGO_TARGET_DIR/nextest/ci/junit.xml`.
+const NEXTEST_CONFIG: &str = r#"[profile.ci]
+fail-fast = false
 
-/// Run `cargo test --workspace --lib` unit tests.
-pub async fn run(client: &Query, source: Directory) -> eyre::Result<String> {
-    let output = containers::rust_base(client, source)
-        .with_exec(vec!["cargo", "test", "--workspace", "--lib"])
-        .stdout()
+[profile.ci.junit]
+path = "junit.xml"
+"#;
+
+/// Runs nextest without aborting so the JUnit report is always copied out of the
+/// cargo-target cache; the exit status is recorded in `/tmp/nextest-exit`. A report left
+/// in the cache by an earlier run is removed first, so a build failure copies none.
+const NEXTEST_SCRIPT: &str = r#"
+rm -f "$CARGO_TARGET_DIR/nextest/ci/junit.xml"
+cargo nextest run $PACKAGES --lib --profile ci --config-file /ci/nextest.toml 2>&1
+echo $? > /tmp/nextest-exit
+cp "$CARGO_TARGET_DIR/nextest/ci/junit.xml" /tmp/junit.xml 2>/dev/null || touch /tmp/junit.xml
+"#;
+
+/// `<binary> <test>` of every JUnit `<testcase>` with a `<failure>` or `<error>`, as
+/// nextest prints them.
+pub fn failing_tests(junit: &str) -> eyre::Result<Vec<String>> {
+    let mut reader = Reader::from_str(junit);
+    let mut current = None;
+    let mut failing = Vec::new();
+    loop {
+        match reader.read_event()? {
+            Event::Start(e) if e.name().as_ref() == b"testcase" => {
+                let attr = |name: &str| -> eyre::Result<String> {
+                    Ok(match e.try_get_attribute(name)? {
+                        Some(a) => a.unescape_value()?.into_owned(),
+                        None => String::new(),
+                    })
+                };
+                current = Some(format!("{} {}", attr("classname")?, attr("name")?));
+            }
+            Event::End(e) if e.name().as_ref() == b"testcase" => current = None,
+            Event::Start(e) | Event::Empty(e)
+                if matches!(e.name().as_ref(), b"failure" | b"error") =>
+            {
+                if let Some(test) = current.take() {
+                    failing.push(test);
+                }
+            }
+            Event::Eof => break,
+            _ => {}
+        }
+    }
+    Ok(failing)
+}
+
+/// Run the unit tests (`--lib`) of `packages`, or of the whole workspace when empty, with
+/// `cargo nextest`, exporting the JUnit report to `junit_output` when given, also when
+/// tests fail; the exported file is empty when the tests failed to build.
+///
+/// Property tests always run with a known seed so a failure can be replayed
+/// with `--proptest-seed`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    opts: &BaseOpts,
+    proptest: &PropTestOpts,
+    packages: &[String],
+    junit_output: Option<&str>,
+) -> eyre::Result<String> {
+    let tested = run_with_junit(client, source, opts, proptest, packages).await?;
+    if let Some(path) = junit_output {
+        client
+            .directory()
+            .with_new_file("junit.xml", tested.junit)
+            .file("junit.xml")
+            .export(path)
+            .await?;
+    }
+    tested.result
+}
+
+/// A finished unit-test run: the stage's result and the JUnit report, empty when the
+/// tests failed to build.
+pub struct Tested {
+    pub result: eyre::Result<String>,
+    pub junit: String,
+}
+
+/// `run` without the export, for callers that use the JUnit report themselves. Errors
+/// only when the tests could not be run at all.
+pub async fn run_with_junit(
+    client: &Query,
+    source: Directory,
+    opts: &BaseOpts,
+    proptest: &PropTestOpts,
+    packages: &[String],
+) -> eyre::Result<Tested> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
         .await?;
 
-    Ok(format!("[test] Unit tests passed.\n{output}"))
+    let seed = match proptest.proptest_seed {
+        Some(seed) => seed,
+        None => SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos() as u64,
+    };
+
+    let toolchain = containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(&["cargo", "install", "cargo-nextest", "--locked"], opts));
+    let scope = if packages.is_empty() {
+        "--workspace".to_string()
+    } else {
+        packages.iter().map(|p| format!("-p {p}")).collect::<Vec<_>>().join(" ")
+    };
+    let mut container = containers::with_source(toolchain, source)
+        .with_new_file("/ci/nextest.toml", NEXTEST_CONFIG)
+        .with_env_variable("PACKAGES", scope)
+        .with_env_variable("PROPTEST_RNG_SEED", seed.to_string());
+    if let Some(cases) = proptest.proptest_cases {
+        container = container.with_env_variable("PROPTEST_CASES", cases.to_string());
+    }
+    if let Some(tests) = proptest.quickcheck_tests.or(proptest.proptest_cases) {
+        container = container.with_env_variable("QUICKCHECK_TESTS", tests.to_string());
+    }
+
+    let tested = container.with_exec(vec!["bash", "-c", NEXTEST_SCRIPT]);
+    let output = tested.stdout().await?;
+    let exit_code = tested.file("/tmp/nextest-exit").contents().await?;
+    let junit = tested.file("/tmp/junit.xml").contents().await?;
+
+    if exit_code.trim() != "0" {
+        // A build failure leaves no report (an empty file); the output says what broke.
+        let failing = failing_tests(&junit).unwrap_or_default();
+        let summary = if junit.trim().is_empty() {
+            "Unit tests failed: build failed, no test report was written.".to_string()
+        } else if failing.is_empty() {
+            "Unit tests failed.".to_string()
+        } else {
+            format!("{} unit test(s) failed:\n  {}", failing.len(), failing.join("\n  "))
+        };
+        let result = Err(eyre::eyre!(
+            "[test] {summary}\n{output}\n\
+             proptest seed: {seed} (reproduce with --proptest-seed {seed})"
+        ));
+        return Ok(Tested { result, junit });
+    }
+
+    let result = Ok(format!("[test] Unit tests passed (proptest seed {seed}).\n{output}"));
+    Ok(Tested { result, junit })
+}
+
+/// `run` over the workspace with `multiplier` times the default property-test cases, for
+/// the nightly pipeline; the PR run keeps the defaults.
+pub async fn extended(
+    client: &Query,
+    source: Directory,
+    multiplier: u32,
+    seed: Option<u64>,
+    junit_output: Option<&str>,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let proptest = PropTestOpts {
+        proptest_seed: seed,
+        proptest_cases: Some(PROPTEST_DEFAULT_CASES.saturating_mul(multiplier)),
+        quickcheck_tests: Some(QUICKCHECK_DEFAULT_TESTS.saturating_mul(multiplier)),
+    };
+    let output = run(client, source, opts, &proptest, &[], junit_output)
+        .await
+        .map_err(|e| eyre::eyre!("[test-extended] {multiplier}x property-test cases:\n{e}"))?;
+    Ok(format!("[test-extended] {multiplier}x property-test cases.\n{output}"))
 }
@@ -0,0 +1,67 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::flaky::{self, RunConfig};
+
+/// Run the unit tests of `packages` (the workspace when empty) in declaration order, then
+/// shuffled from `seed` (fresh and printed when unset), both with `threads` libtest
+/// threads, and fail if any test's outcome differs between the two: a test that passes
+/// in one order only depends on state another test leaves behind. nextest can't shuffle,
+/// so this uses libtest's `--shuffle-seed`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    seed: Option<u64>,
+    threads: usize,
+    packages: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let seed = match seed {
+        Some(seed) => seed,
+        None => SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos() as u64,
+    };
+    let threads = threads.max(1);
+    let configs = [
+        RunConfig { threads, shuffle_seed: None },
+        RunConfig { threads, shuffle_seed: Some(seed) },
+    ];
+    let results = flaky::runs(client, source, packages, &configs, opts)
+        .await
+        .map_err(|e| eyre::eyre!("[test-order] {e}"))?;
+    let (ordered, shuffled) = (&results[0], &results[1]);
+
+    let outcome = |passed: Option<&bool>| match passed {
+        Some(true) => "ok",
+        Some(false) => "FAILED",
+        None => "not run",
+    };
+    let mut report = String::new();
+    let names = ordered.keys().chain(shuffled.keys().filter(|n| !ordered.contains_key(*n)));
+    for name in names {
+        let (a, b) = (ordered.get(name), shuffled.get(name));
+        if a != b {
+            report.push_str(&format!(
+                "  {name}: {} in order, {} shuffled\n",
+                outcome(a),
+                outcome(b)
+            ));
+        }
+    }
+
+    if !report.is_empty() {
+        return Err(eyre::eyre!(
+            "[test-order] Results depend on test order (reproduce with --seed {seed}):\n\
+             {report}"
+        ));
+    }
+
+    Ok(format!(
+        "[test-order] {} test(s) gave the same results in order and shuffled (seed {seed}).",
+        ordered.len()
+    ))
+}
@@ -0,0 +1,70 @@
+use std::time::Instant;
+
+use dagger_sdk::{Directory, Query};
+use futures::future::join_all;
+
+use crate::containers::{self, BaseOpts};
+use crate::metadata;
+use crate::stages::test;
+
+/// Run the workspace unit tests split across `shards` concurrent containers.
+///
+/// Library crates are dealt round-robin into the shards; each shard runs `test` over its
+/// crates in its own target subdir, so shards don't serialize on cargo's build lock but
+/// do compile shared dependencies once each. The shard reports are merged and the run
+/// fails if any shard does.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    shards: usize,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let workspace = metadata::load(client, source.clone(), opts).await?;
+    // `test` runs `--lib` only, so crates without a library have nothing to shard.
+    let crates: Vec<String> =
+        workspace.members().filter(|p| p.has_lib()).map(|p| p.name.clone()).collect();
+    if crates.is_empty() {
+        return Ok("[test-sharded] No library crates to test.".to_string());
+    }
+
+    let mut groups: Vec<Vec<String>> = vec![Vec::new(); shards.clamp(1, crates.len())];
+    for (i, krate) in crates.into_iter().enumerate() {
+        let slot = i % groups.len();
+        groups[slot].push(krate);
+    }
+
+    let started = Instant::now();
+    let results = join_all(groups.iter().enumerate().map(|(shard, group)| {
+        let source = source.clone();
+        let opts = BaseOpts { target_subdir: Some(format!("test-shard-{shard}")), ..opts.clone() };
+        async move { test::run(client, source, &opts, &Default::default(), group, None).await }
+    }))
+    .await;
+    let elapsed = started.elapsed();
+
+    let mut report = String::new();
+    let mut failed = Vec::new();
+    for (shard, (group, result)) in groups.iter().zip(&results).enumerate() {
+        let header = format!("--- shard {shard}: {} ---", group.join(", "));
+        match result {
+            Ok(output) => report.push_str(&format!("{header}\n{output}\n")),
+            Err(err) => {
+                failed.push(shard.to_string());
+                report.push_str(&format!("{header} FAILED\n{err}\n"));
+            }
+        }
+    }
+
+    let summary = format!("{} shard(s) in {:.1}s", groups.len(), elapsed.as_secs_f64());
+    if !failed.is_empty() {
+        return Err(eyre::eyre!(
+            "[test-sharded] Shard(s) {} failed ({summary})\n{report}",
+            failed.join(", ")
+        ));
+    }
+
+    Ok(format!("[test-sharded] Unit tests passed across {summary}.\n{report}"))
+}
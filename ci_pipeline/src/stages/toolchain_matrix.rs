@@ -0,0 +1,76 @@
+use dagger_sdk::{Directory, Query};
+use futures::future::join_all;
+
+use crate::containers::{BaseOpts, RustChannel};
+use crate::stages::{check, test};
+
+/// `opts` retargeted at one matrix entry: `beta`/`nightly` select a channel, `stable`
+/// keeps the configured `--rust-version`, anything else is a Rust version. Each entry
+/// builds in its own target subdir so the entries don't serialize on cargo's lock, and
+/// never on a `--base-image`, whose toolchain is fixed.
+fn toolchain_opts(toolchain: &str, opts: &BaseOpts) -> eyre::Result<BaseOpts> {
+    let mut opts = BaseOpts {
+        base_image: None,
+        target_subdir: Some(format!("toolchain-{toolchain}")),
+        ..opts.clone()
+    };
+    match toolchain {
+        "stable" => opts.rust_channel = RustChannel::Stable,
+        "beta" => opts.rust_channel = RustChannel::Beta,
+        "nightly" => opts.rust_channel = RustChannel::Nightly,
+        version => {
+            opts.rust_channel = RustChannel::Stable;
+            if version != opts.rust_version {
+                // A pinned digest belongs to the configured version's tag.
+                opts.rust_base_digest = None;
+                opts.rust_version = version.to_string();
+            }
+        }
+    }
+    opts.validate()?;
+    Ok(opts)
+}
+
+/// Run check and the unit tests on every toolchain in `toolchains` in parallel and
+/// report pass/fail per toolchain, failing if any toolchain fails.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    toolchains: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let mut entries = Vec::new();
+    for toolchain in toolchains {
+        entries.push((toolchain.as_str(), toolchain_opts(toolchain, opts)?));
+    }
+
+    let results = join_all(entries.iter().map(|(_, opts)| {
+        let source = source.clone();
+        async move {
+            check::run(client, source.clone(), opts).await?;
+            test::run(client, source, opts, &Default::default(), &[], None).await
+        }
+    }))
+    .await;
+
+    let mut report = String::new();
+    let mut failed = Vec::new();
+    for ((toolchain, _), result) in entries.iter().zip(&results) {
+        match result {
+            Ok(_) => report.push_str(&format!("  {toolchain}: ok\n")),
+            Err(err) => {
+                failed.push(*toolchain);
+                report.push_str(&format!("  {toolchain}: FAILED\n{err}\n"));
+            }
+        }
+    }
+
+    if !failed.is_empty() {
+        return Err(eyre::eyre!(
+            "[toolchain-matrix] Failed on: {}\n{report}",
+            failed.join(", ")
+        ));
+    }
+
+    Ok(format!("[toolchain-matrix] Check + test passed on every toolchain.\n{report}"))
+}
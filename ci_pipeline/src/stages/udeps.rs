@@ -0,0 +1,80 @@
+use std::collections::BTreeMap;
+
+use dagger_sdk::{Directory, Query};
+use serde::Deserialize;
+
+use crate::containers::{self, BaseOpts, RustChannel};
+
+/// Keeps going when cargo-udeps exits non-zero (it does when it finds unused
+/// dependencies) so its JSON report and log can be read back.
+const UDEPS_SCRIPT: &str = r#"
+cargo udeps --workspace --all-targets --output json > /tmp/udeps.json 2> /tmp/udeps.log || true
+"#;
+
+/// `cargo udeps --output json`.
+#[derive(Deserialize)]
+struct UdepsReport {
+    unused_deps: BTreeMap<String, UnusedDeps>,
+}
+
+#[derive(Deserialize)]
+struct UnusedDeps {
+    #[serde(default)]
+    normal: Vec<String>,
+    #[serde(default)]
+    development: Vec<String>,
+    #[serde(default)]
+    build: Vec<String>,
+}
+
+/// Find unused dependencies with `cargo udeps` on the nightly toolchain (in its own
+/// target subdir). Unused dependencies fail the stage unless `warn_only` is set, in
+/// which case they are only listed.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    warn_only: bool,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let opts = BaseOpts {
+        rust_channel: RustChannel::Nightly,
+        // A prebuilt image carries its own toolchain, not nightly.
+        base_image: None,
+        target_subdir: Some("udeps".to_string()),
+        ..opts.clone()
+    };
+    let toolchain = containers::rust_toolchain(client, &opts)
+        .with_exec(containers::retried(&["cargo", "install", "cargo-udeps", "--locked"], &opts));
+    let ran = containers::with_source(toolchain, source)
+        .with_exec(vec!["bash", "-c", UDEPS_SCRIPT]);
+
+    let json = ran.file("/tmp/udeps.json").contents().await?;
+    let report: UdepsReport = match serde_json::from_str(&json) {
+        Ok(report) => report,
+        Err(_) => {
+            let log = ran.file("/tmp/udeps.log").contents().await?;
+            return Err(eyre::eyre!("[udeps] cargo udeps did not produce a report:\n{log}"));
+        }
+    };
+
+    let mut unused = Vec::new();
+    for (package, deps) in &report.unused_deps {
+        // Package IDs start with the crate name.
+        let name = package.split_whitespace().next().unwrap_or(package);
+        let kinds = [("", &deps.normal), (" (dev)", &deps.development), (" (build)", &deps.build)];
+        for (kind, list) in kinds {
+            for dep in list {
+                unused.push(format!("{name}: {dep}{kind}"));
+            }
+        }
+    }
+
+    if unused.is_empty() {
+        return Ok("[udeps] No unused dependencies.".to_string());
+    }
+    let list = format!("{} unused dependencies:\n  {}", unused.len(), unused.join("\n  "));
+    if warn_only {
+        return Ok(format!("[udeps] WARNING: {list}"));
+    }
+    Err(eyre::eyre!("[udeps] {list}"))
+}
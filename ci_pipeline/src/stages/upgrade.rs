@@ -0,0 +1,117 @@
+use std::collections::BTreeMap;
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Container, Directory, File, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+use crate::stages::module_lifecycle::valid_identifier;
+
+/// Restores a custom-format (`PGDMP` magic) or plain SQL dump into `$DATABASE_URL`.
+const RESTORE_SCRIPT: &str = r#"
+set -euo pipefail
+if [ "$(head -c 5 /ci/baseline.dump)" = "PGDMP" ]; then
+    pg_restore --no-owner --no-privileges --exit-on-error -d "$DATABASE_URL" /ci/baseline.dump
+else
+    psql "$DATABASE_URL" -v ON_ERROR_STOP=1 -q -f /ci/baseline.dump
+fi
+"#;
+
+const UPGRADE_SCRIPT: &str = r#"
+set -euo pipefail
+BINARY="./target/release/erp-server"
+echo "[upgrade] Running migrations..."
+$BINARY migrate 2>&1
+echo "[upgrade] Upgrading all modules..."
+$BINARY module upgrade --all 2>&1
+"#;
+
+/// Row count of each of `tables`, as `ROWS <table> <count>` lines.
+pub fn count_rows(container: &Container, tables: &[String]) -> Container {
+    let sql: Vec<String> = tables
+        .iter()
+        .map(|t| format!("SELECT 'ROWS {t} ' || COUNT(*) FROM {t}"))
+        .collect();
+    container.with_exec(vec![
+        "sh".to_string(),
+        "-c".to_string(),
+        r#"psql "$DATABASE_URL" -At -c "$0""#.to_string(),
+        sql.join(" UNION ALL "),
+    ])
+}
+
+/// Per-table counts from `count_rows` output.
+pub fn parse_counts(output: &str) -> BTreeMap<String, u64> {
+    output
+        .lines()
+        .filter_map(|line| {
+            let mut parts = line.strip_prefix("ROWS ")?.split_whitespace();
+            Some((parts.next()?.to_string(), parts.next()?.parse().ok()?))
+        })
+        .collect()
+}
+
+/// Restore an old-release `baseline_dump` into a fresh PostgreSQL, upgrade it with the
+/// new `erp-server` (`migrate`, then `module upgrade --all`), and fail if any of `tables`
+/// lost rows in the process.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    baseline_dump: File,
+    tables: &[String],
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    if let Some(table) = tables.iter().find(|t| !valid_identifier(t)) {
+        return Err(eyre::eyre!("invalid table name '{table}'"));
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    // Restore and upgrade run in different containers: keep the database running
+    // between them so the restored data survives.
+    let pg = containers::ready_postgres(client, opts).await?;
+    let db_url = containers::pg_url(client);
+
+    // The database is fresh every run; never let Dagger answer the restore or the
+    // upgrade from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos().to_string();
+
+    // pg_restore from the server's own image, so newer dump formats restore.
+    let restored = client
+        .container()
+        .from(containers::pg_image(opts))
+        .with_service_binding("db", pg.clone())
+        .with_secret_variable("DATABASE_URL", db_url.clone())
+        .with_file("/ci/baseline.dump", baseline_dump)
+        .with_env_variable("CI_UPGRADE_RUN", nonce.as_str())
+        .with_exec(vec!["bash", "-c", RESTORE_SCRIPT]);
+    let before = parse_counts(&count_rows(&restored, tables).stdout().await?);
+
+    let upgraded = integration::server_env(client, source, pg.clone(), db_url, opts)
+        .with_env_variable("CI_UPGRADE_RUN", nonce.as_str())
+        .with_exec(vec!["bash", "-c", UPGRADE_SCRIPT]);
+    let upgrade_log = upgraded.stdout().await?;
+    let after = parse_counts(&count_rows(&upgraded, tables).stdout().await?);
+
+    pg.stop().await?;
+
+    let mut report = String::new();
+    let mut lost = Vec::new();
+    for table in tables {
+        let (b, a) = (before.get(table).copied(), after.get(table).copied());
+        report.push_str(&format!("  {table}: {b:?} -> {a:?}\n"));
+        if a < b {
+            lost.push(table.as_str());
+        }
+    }
+
+    if !lost.is_empty() {
+        return Err(eyre::eyre!(
+            "[upgrade] Rows lost during upgrade in: {}\n{report}{upgrade_log}",
+            lost.join(", ")
+        ));
+    }
+
+    Ok(format!("[upgrade] Baseline database upgraded cleanly.\n{report}{upgrade_log}"))
+}
@@ -0,0 +1,103 @@
+use dagger_sdk::{Directory, Query};
+use quick_xml::events::Event;
+use quick_xml::Reader;
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+const SMOKE_SCRIPT: &str = r#"
+for i in $(seq 1 60); do curl -sf "$BASE_URL/health" > /dev/null && break; sleep 1; done
+
+for entry in $VIEWS; do
+    xmlid="${entry%%=*}"
+    path="${entry#*=}"
+    status=$(curl -s -o /tmp/body -w '%{http_code}' "$BASE_URL$path")
+    if [ "$status" = "200" ]; then
+        echo "VIEW_OK $xmlid"
+    else
+        echo "VIEW_FAIL $xmlid HTTP $status: $(head -c 300 /tmp/body | tr '\n' ' ')"
+    fi
+done
+"#;
+
+/// Install `module`, start the server, and request every view the module declares
+/// (`<record model="ir.ui.view">` in its XML), failing on any non-200 render.
+/// `render_path` is the server route with `{xmlid}` standing for `module.view_id`.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    module: &str,
+    render_path: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let mut views = Vec::new();
+    for path in source.glob(format!("modules/{module}/**/*.xml")).await? {
+        let xml = source.file(path.as_str()).contents().await?;
+        views.extend(
+            view_ids(&xml)
+                .map_err(|e| eyre::eyre!("{path}: {e}"))?
+                .into_iter()
+                .map(|id| if id.contains('.') { id } else { format!("{module}.{id}") }),
+        );
+    }
+    if views.is_empty() {
+        return Ok(format!("[view-render] {module} declares no views."));
+    }
+
+    let entries: Vec<String> = views
+        .iter()
+        .map(|xmlid| format!("{xmlid}={}", render_path.replace("{xmlid}", xmlid)))
+        .collect();
+
+    let pg = containers::ready_postgres(client, opts).await?;
+    let server = integration::server_service(client, source, pg, &[module], opts);
+
+    let output = client
+        .container()
+        .from("curlimages/curl:8.11.1")
+        .with_service_binding("erp", server)
+        .with_env_variable(
+            "BASE_URL",
+            format!("http://erp:{}", integration::SERVER_PORT),
+        )
+        .with_env_variable("VIEWS", entries.join(" "))
+        .with_exec(vec!["sh", "-c", SMOKE_SCRIPT])
+        .stdout()
+        .await?;
+
+    let failures: Vec<&str> = output
+        .lines()
+        .filter_map(|line| line.strip_prefix("VIEW_FAIL "))
+        .collect();
+    if !failures.is_empty() {
+        return Err(eyre::eyre!(
+            "[view-render] {} of {} view(s) failed to render:\n  {}",
+            failures.len(),
+            views.len(),
+            failures.join("\n  ")
+        ));
+    }
+
+    Ok(format!("[view-render] All {} view(s) of {module} rendered.", views.len()))
+}
+
+/// `id` attributes of `<record model="ir.ui.view">` elements.
+fn view_ids(xml: &str) -> eyre::Result<Vec<String>> {
+    let mut reader = Reader::from_str(xml);
+    let mut ids = Vec::new();
+    loop {
+        match reader.read_event()? {
+            Event::Start(e) | Event::Empty(e) if e.name().as_ref() == b"record" => {
+                let model = e.try_get_attribute("model")?;
+                if model.is_some_and(|m| m.value.as_ref() == b"ir.ui.view") {
+                    if let Some(id) = e.try_get_attribute("id")? {
+                        ids.push(id.unescape_value()?.into_owned());
+                    }
+                }
+            }
+            Event::Eof => break,
+            _ => {}
+        }
+    }
+    Ok(ids)
+}
@@ -0,0 +1,43 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+
+/// Builds the dependencies from a cargo-chef recipe in each profile the pipeline uses:
+/// debug with test targets (check, test), clippy (lint) and release (integration).
+const COOK_SCRIPT: &str = r#"
+set -euo pipefail
+echo "--- debug + tests ---"
+cargo chef cook --recipe-path /ci/recipe.json --tests 2>&1 | tail -2
+echo "--- clippy ---"
+cargo chef cook --recipe-path /ci/recipe.json --clippy 2>&1 | tail -2
+echo "--- release ---"
+cargo chef cook --recipe-path /ci/recipe.json --release 2>&1 | tail -2
+du -sh "$CARGO_TARGET_DIR"
+"#;
+
+/// Prebuild every workspace dependency into the `cargo-target` cache volume, for a
+/// nightly schedule so PR pipelines start hot. cargo-chef reduces the workspace to a
+/// dependency-only recipe, so the cook layer is keyed on `Cargo.lock` and the manifests
+/// rather than on the source; it is still forced to run every time, since the cache
+/// volume itself may have been pruned since the last run.
+pub async fn run(client: &Query, source: Directory, opts: &BaseOpts) -> eyre::Result<String> {
+    let chef = containers::rust_toolchain(client, opts)
+        .with_exec(containers::retried(&["cargo", "install", "cargo-chef", "--locked"], opts));
+    containers::ensure_free_disk(&chef, opts).await?;
+
+    let recipe = containers::with_source(chef.clone(), source)
+        .with_exec(vec!["cargo", "chef", "prepare", "--recipe-path", "/tmp/recipe.json"])
+        .file("/tmp/recipe.json");
+
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let output = chef
+        .with_file("/ci/recipe.json", recipe)
+        .with_env_variable("CI_WARM_CACHE_RUN", nonce.to_string())
+        .with_exec(vec!["bash", "-c", COOK_SCRIPT])
+        .stdout()
+        .await?;
+
+    Ok(format!("[warm-cache] Dependencies built into the cargo-target cache.\n{output}"))
+}
@@ -0,0 +1,92 @@
+use std::time::{SystemTime, UNIX_EPOCH};
+
+use dagger_sdk::{Directory, Query};
+
+use crate::containers::{self, BaseOpts};
+use crate::stages::integration;
+
+/// Must match the `@playwright/test` version the suite pins, which expects these browsers.
+const PLAYWRIGHT_IMAGE: &str = "mcr.microsoft.com/playwright:v1.49.1-noble";
+
+/// Host variable holding the password the flows log in with; exposed to the suite as
+/// `E2E_PASSWORD` through a secret.
+const PASSWORD_ENV: &str = "CI_E2E_PASSWORD";
+
+/// Polls `/health` for up to two minutes, so the flows start against a ready server.
+const WAIT_JS: &str = r#"
+for (let i = 0; i < 120; i++) {
+    try {
+        if ((await fetch(`${process.env.BASE_URL}/health`)).ok) process.exit(0);
+    } catch {}
+    await new Promise((resolve) => setTimeout(resolve, 1000));
+}
+console.error("server not ready after 120s");
+process.exit(1);
+"#;
+
+/// Runs the suite, keeping traces of failed tests, and records its exit status in
+/// `/ci/status` so the results are exported before the stage fails.
+const TEST_SCRIPT: &str = r#"
+mkdir -p /ci/results
+npx playwright test --reporter=list --trace=retain-on-failure --output=/ci/results
+echo $? > /ci/status
+"#;
+
+/// Start `erp-server` with `modules` installed and run the Playwright project in `suite`
+/// (login and CRUD flows through `erp_web`) against it, with `BASE_URL`, and
+/// `E2E_PASSWORD` when `CI_E2E_PASSWORD` is set. Traces, and the screenshots the suite's
+/// config takes on failure, are exported to `artifacts` when any test fails.
+pub async fn run(
+    client: &Query,
+    source: Directory,
+    suite: &str,
+    modules: &[String],
+    artifacts: &str,
+    opts: &BaseOpts,
+) -> eyre::Result<String> {
+    let suite_dir = source.directory(suite);
+    if !suite_dir.entries().await?.iter().any(|entry| entry == "package.json") {
+        return Err(eyre::eyre!("[web-e2e] No package.json found in {suite}"));
+    }
+
+    containers::ensure_free_disk(&containers::rust_base(client, source.clone(), opts), opts)
+        .await?;
+
+    let modules: Vec<&str> = modules.iter().map(String::as_str).collect();
+    let pg = containers::ready_postgres(client, opts).await?;
+    let server = integration::server_service(client, source.clone(), pg, &modules, opts);
+
+    let mut playwright = client
+        .container()
+        .from(PLAYWRIGHT_IMAGE)
+        .with_service_binding("erp", server)
+        .with_env_variable("BASE_URL", format!("http://erp:{}", integration::SERVER_PORT))
+        .with_env_variable("CI", "true")
+        .with_new_file("/ci/wait.mjs", WAIT_JS)
+        .with_directory("/suite", suite_dir)
+        .with_workdir("/suite")
+        .with_exec(containers::retried(&["npm", "ci"], opts));
+    let password = std::env::var(PASSWORD_ENV).unwrap_or_default();
+    if !password.is_empty() {
+        let password = client.set_secret("e2e-password", password);
+        playwright = playwright.with_secret_variable("E2E_PASSWORD", password);
+    }
+
+    // The flows write through the running server; never let Dagger answer from cache.
+    let nonce = SystemTime::now().duration_since(UNIX_EPOCH)?.as_nanos();
+    let tested = playwright
+        .with_env_variable("CI_E2E_RUN", nonce.to_string())
+        .with_exec(vec!["node", "/ci/wait.mjs"])
+        .with_exec(vec!["sh", "-c", TEST_SCRIPT]);
+    let output = tested.stdout().await?;
+
+    if tested.file("/ci/status").contents().await?.trim() != "0" {
+        tested.directory("/ci/results").export(artifacts).await?;
+        return Err(eyre::eyre!(
+            "[web-e2e] Playwright flows failed; traces and screenshots exported to \
+             {artifacts}.\n{output}"
+        ));
+    }
+
+    Ok(format!("[web-e2e] Playwright flows passed.\n{output}"))
+}